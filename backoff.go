@@ -0,0 +1,48 @@
+package nmealogger
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially increasing delays with random jitter for
+// retrying connections. The zero value is not usable, use NewBackoff.
+type Backoff struct {
+	Min    time.Duration
+	Max    time.Duration
+	Factor float64
+	// Jitter is the fraction of the delay that is randomized, eg. with 0.2 a
+	// delay of 10s becomes something between 8s and 12s.
+	Jitter float64
+
+	current time.Duration
+}
+
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{
+		Min:    min,
+		Max:    max,
+		Factor: 2,
+		Jitter: 0.2,
+	}
+}
+
+// Next returns the delay to wait before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.Min
+	} else {
+		b.current = time.Duration(float64(b.current) * b.Factor)
+	}
+	if b.current > b.Max {
+		b.current = b.Max
+	}
+
+	delta := b.Jitter * float64(b.current)
+	return b.current + time.Duration(delta*(2*rand.Float64()-1))
+}
+
+// Reset starts the delays over from Min, call it after a successful attempt.
+func (b *Backoff) Reset() {
+	b.current = 0
+}
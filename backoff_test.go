@@ -0,0 +1,34 @@
+package nmealogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(time.Second, 10*time.Second)
+	b.Jitter = 0
+
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+	for _, e := range expected {
+		if delay := b.Next(); delay != e*time.Second {
+			t.Fatalf("Expected delay %v, got %v", e*time.Second, delay)
+		}
+	}
+
+	b.Reset()
+	if delay := b.Next(); delay != time.Second {
+		t.Fatalf("Expected delay to be reset to %v, got %v", time.Second, delay)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := NewBackoff(10*time.Second, time.Minute)
+
+	for i := 0; i < 100; i++ {
+		b.Reset()
+		if delay := b.Next(); delay < 8*time.Second || delay > 12*time.Second {
+			t.Fatalf("Delay %v is out of jitter range", delay)
+		}
+	}
+}
@@ -1,83 +1,178 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/option"
+	nmealogger "github.com/mpihlak/go-nmealogger"
+	"github.com/mpihlak/go-nmealogger/storage"
 )
 
 func main() {
-	logDirectory := flag.String("logDir", "data", "Directory where downloaded log files are stored")
-	credentialsFile := flag.String("credentials", "nmealogger-5cf95ba688f5.json", "Location of Google Drive client credentials")
-	parentFolderID := flag.String("folderId", "1Jes5cUmB_MMk4U2qkC7SiJCeT_jBFV0Y", "ID of the data folder in Google Drive")
-	deleteFiles := flag.Bool("delete", false, "Delete files from Drive after successful download")
-	download := flag.Bool("download", true, "Download files from Drive")
-	flag.Parse()
+	cfg := nmealogger.DefaultConfig()
+	c := &cfg.LogDownload
+	flag.StringVar(&c.LogDir, "logDir", c.LogDir, "Directory where downloaded log files are stored")
+	flag.StringVar(&cfg.Storage.Backend, "storage", cfg.Storage.Backend, "Where the logs are stored: drive, s3, webdav, sftp or dir")
+	flag.StringVar(&cfg.Storage.Dir, "storageDir", cfg.Storage.Dir, "Directory the logs are stored in with -storage dir")
+	flag.StringVar(&cfg.Drive.Credentials, "credentials", cfg.Drive.Credentials, "Location of Google Drive client credentials")
+	flag.StringVar(&cfg.Drive.FolderID, "folderId", cfg.Drive.FolderID, "ID of the data folder in Google Drive")
+	flag.BoolVar(&c.Delete, "delete", c.Delete, "Delete files from the storage after successful download")
+	flag.BoolVar(&c.Download, "download", c.Download, "Download files from the storage")
+	flag.BoolVar(&c.List, "list", c.List, "Only list the metadata of the logs in the storage")
+	flag.StringVar(&c.After, "after", c.After, "Only process logs that end after this UTC date or time, eg. 2024-07-15 or 2024-07-15T13:00:00")
+	flag.StringVar(&c.Before, "before", c.Before, "Only process logs that start before this UTC date or time")
+	flag.Float64Var(&c.MinDistance, "minDistance", c.MinDistance, "Only process logs with at least this distance sailed, in nautical miles")
+	nmealogger.ParseConfig(cfg, func() error {
+		return errors.Join(c.Validate(), cfg.ValidateStorage())
+	})
 
 	ctx := context.Background()
-	srv, err := drive.NewService(ctx, option.WithCredentialsFile(*credentialsFile), option.WithScopes(drive.DriveScope))
+	store, err := storage.New(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Warning: Unable to create drive Client %v", err)
+		log.Fatalf("Error opening storage: %v", err)
 	}
+	defer store.Close()
 
-	// List files at the folder
-	log.Printf("Listing files at Drive folder %s", *parentFolderID)
-	var files []*drive.File
-	numFiles := 0
-	pageToken := ""
-	for {
-		q := srv.Files.List().Q(fmt.Sprintf("'%s' in parents", *parentFolderID))
-		if pageToken != "" {
-			q = q.PageToken(pageToken)
-		}
-		r, err := q.Do()
-		if err != nil {
-			log.Fatalf("Error listing files in Drive: %v\n", err)
-		}
+	log.Printf("Listing files in %s", store)
+	files, err := store.List(ctx)
+	if err != nil {
+		log.Fatalf("Error listing files: %v", err)
+	}
 
-		files = append(files, r.Files...)
-		numFiles += len(r.Files)
-		pageToken = r.NextPageToken
-		if pageToken == "" {
-			break
-		}
+	filter := newMetadataFilter(c)
+	var metadata map[string]*nmealogger.LogMetadata
+	if c.List || filter.enabled() {
+		metadata = downloadMetadata(ctx, store, files)
+	}
+
+	if c.List {
+		listMetadata(files, metadata, filter)
+		return
 	}
 
-	log.Printf("Processing files to %s", *logDirectory)
+	log.Printf("Processing files to %s", c.LogDir)
+	numFiles := 0
 	for _, file := range files {
-		if *download {
-			log.Printf("Downloading: %s\n", file.Name)
-			resp, err := srv.Files.Get(file.Id).Download()
-			if err != nil {
-				log.Fatalf("Error downloading file %s %s: %v", file.Id, file.Name, err)
+		if filter.enabled() {
+			m := metadata[nmealogger.LogBaseName(file.Name)]
+			if m == nil {
+				log.Printf("No metadata, skipping: %s", file.Name)
+				continue
 			}
+			if !filter.match(m) {
+				continue
+			}
+		}
+		numFiles++
 
-			fileName := filepath.Join(*logDirectory, file.Name)
+		if c.Download {
+			log.Printf("Downloading: %s\n", file.Name)
+			fileName := filepath.Join(c.LogDir, file.Name)
 			log.Printf("Writing to %s", fileName)
 			outFile, err := os.Create(fileName)
 			if err != nil {
 				log.Fatalf("Error creating output file %s: %v", fileName, err)
 			}
 
-			io.Copy(outFile, resp.Body)
+			err = store.Download(ctx, file.Name, outFile)
 			outFile.Close()
-			resp.Body.Close()
+			if err != nil {
+				log.Fatalf("Error downloading file %s: %v", file.Name, err)
+			}
 		}
 
-		if *deleteFiles {
+		if c.Delete {
 			log.Printf("Deleting: %s\n", file.Name)
-			if err := srv.Files.Delete(file.Id).Do(); err != nil {
-				log.Fatalf("Error deleting file %s %s: %v", file.Id, file.Name, err)
+			if err := store.Delete(ctx, file.Name); err != nil {
+				log.Fatalf("Error deleting file %s: %v", file.Name, err)
 			}
 		}
 	}
 
 	log.Printf("Done, %d files processed.", numFiles)
 }
+
+// downloadMetadata downloads the metadata files, which are small, and returns
+// them by the base name of the log.
+func downloadMetadata(ctx context.Context, store storage.Storage, files []storage.File) map[string]*nmealogger.LogMetadata {
+	metadata := make(map[string]*nmealogger.LogMetadata)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name, nmealogger.MetadataSuffix) {
+			continue
+		}
+
+		var data bytes.Buffer
+		if err := store.Download(ctx, file.Name, &data); err != nil {
+			log.Fatalf("Error downloading file %s: %v", file.Name, err)
+		}
+
+		m, err := nmealogger.ParseLogMetadata(data.Bytes())
+		if err != nil {
+			log.Printf("%s: %v", file.Name, err)
+			continue
+		}
+		metadata[nmealogger.LogBaseName(file.Name)] = m
+	}
+
+	return metadata
+}
+
+func listMetadata(files []storage.File, metadata map[string]*nmealogger.LogMetadata, filter metadataFilter) {
+	listed := make(map[string]bool)
+	for _, file := range files {
+		baseName := nmealogger.LogBaseName(file.Name)
+		m := metadata[baseName]
+		if listed[baseName] || m == nil || !filter.match(m) {
+			continue
+		}
+		listed[baseName] = true
+
+		sentences := 0
+		for _, count := range m.Sentences {
+			sentences += count
+		}
+		area := ""
+		if box := m.BoundingBox; box != nil {
+			area = fmt.Sprintf("  %.4f,%.4f - %.4f,%.4f", box.MinLatitude, box.MinLongitude, box.MaxLatitude, box.MaxLongitude)
+		}
+		fmt.Printf("%s  %s  %8v  %7d sentences  %5d rejects  %6.1fnm%s\n",
+			m.File, m.Start.Format(time.DateTime), m.End.Sub(m.Start).Round(time.Second),
+			sentences, m.Rejects, m.Distance, area)
+	}
+}
+
+type metadataFilter struct {
+	after       time.Time
+	before      time.Time
+	minDistance float64
+}
+
+// newMetadataFilter returns the filter for the config, which has already
+// been validated.
+func newMetadataFilter(c *nmealogger.LogDownloadConfig) metadataFilter {
+	after, _ := nmealogger.ParseFilterTime(c.After)
+	before, _ := nmealogger.ParseFilterTime(c.Before)
+	return metadataFilter{after: after, before: before, minDistance: c.MinDistance}
+}
+
+func (f metadataFilter) enabled() bool {
+	return !f.after.IsZero() || !f.before.IsZero() || f.minDistance > 0
+}
+
+func (f metadataFilter) match(m *nmealogger.LogMetadata) bool {
+	if !f.after.IsZero() && m.End.Before(f.after) {
+		return false
+	}
+	if !f.before.IsZero() && !m.Start.Before(f.before) {
+		return false
+	}
+	return m.Distance >= f.minDistance
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// Matches the timestamp in log file names such as nmea-2024-07-15T130949.log
+var fileNameTimestamp = regexp.MustCompile(`^(.*?)(\d{4}-\d{2}-\d{2}T\d{6})(.*)$`)
+
+func main() {
+	cfg := nmealogger.DefaultConfig()
+	c := &cfg.LogTimeFix
+	flag.StringVar(&c.OutputDir, "outputDir", c.OutputDir, "Write the corrected files to this directory, replace the input files if empty")
+	flag.BoolVar(&c.DryRun, "dryRun", c.DryRun, "Only report the corrections, don't write any files")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] logfile ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	nmealogger.ParseConfig(cfg, func() error { return nil })
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if c.OutputDir != "" && !c.DryRun {
+		if err := os.MkdirAll(c.OutputDir, os.ModePerm); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
+	}
+
+	filesFixed := 0
+	for _, fileName := range flag.Args() {
+		if err := fixFile(fileName, c); err != nil {
+			log.Printf("%s: %v", fileName, err)
+			continue
+		}
+		filesFixed++
+	}
+	log.Printf("Done, %d of %d files corrected.", filesFixed, flag.NArg())
+}
+
+// logLine is a line of the input file. Lines that can't be parsed are
+// copied to the output as is.
+type logLine struct {
+	raw   string
+	entry int
+}
+
+func fixFile(fileName string, cfg *nmealogger.LogTimeFixConfig) error {
+	lines, entries, err := readLog(fileName)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no log entries")
+	}
+
+	correction, err := nmealogger.EstimateTimeCorrection(entries)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range correction.Segments {
+		log.Printf("%s: from entry %d: offset %v, drift %+.1fppm, %d GPS time samples",
+			fileName, segment.FirstEntry, segment.Offset.Round(time.Millisecond), segment.Drift*1e6, segment.Samples)
+	}
+
+	outputDir := cfg.OutputDir
+	if outputDir == "" {
+		outputDir = filepath.Dir(fileName)
+	}
+	firstTime := correction.Correct(0, entries[0].Time)
+	outputName := filepath.Join(outputDir, correctedFileName(filepath.Base(fileName), firstTime))
+	log.Printf("%s: writing to %s", fileName, outputName)
+
+	if cfg.DryRun {
+		return nil
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	// The corrected name is the same as the original if the time was right
+	inPlace := outputName == filepath.Clean(fileName)
+	move := cfg.OutputDir == "" && !inPlace
+	if err := checkSiblings(fileName, outputName); err != nil {
+		return err
+	}
+	if err := writeLog(outputName, info.Mode().Perm(), inPlace, lines, entries, correction); err != nil {
+		return err
+	}
+
+	if move {
+		if err := os.Remove(fileName); err != nil {
+			return fmt.Errorf("error removing original file: %w", err)
+		}
+	}
+
+	return fixSiblings(fileName, outputName, move, entries, correction)
+}
+
+// The files written next to each log, which are renamed with it
+var siblingSuffixes = []string{nmealogger.MetadataSuffix, ".rejects", ".events"}
+
+// checkSiblings checks that the files next to the log can be given the
+// corrected name without overwriting anything, before the log is written.
+func checkSiblings(fileName, outputName string) error {
+	base, outputBase := nmealogger.LogBaseName(fileName), nmealogger.LogBaseName(outputName)
+	for _, suffix := range siblingSuffixes {
+		if filepath.Clean(base+suffix) == outputBase+suffix {
+			continue
+		}
+		if _, err := os.Lstat(base + suffix); err != nil {
+			continue
+		}
+		if _, err := os.Lstat(outputBase + suffix); err == nil {
+			return fmt.Errorf("%s already exists", outputBase+suffix)
+		}
+	}
+	return nil
+}
+
+// fixSiblings gives the metadata, rejects and events files of the log the
+// corrected name, moving them if move is set and copying them otherwise. The
+// start and end times in the metadata are corrected.
+func fixSiblings(fileName, outputName string, move bool, entries []nmealogger.LogEntry, correction *nmealogger.TimeCorrection) error {
+	base, outputBase := nmealogger.LogBaseName(fileName), nmealogger.LogBaseName(outputName)
+	for _, suffix := range []string{".rejects", ".events"} {
+		from, to := base+suffix, outputBase+suffix
+		if filepath.Clean(from) == to {
+			continue
+		}
+		err := copyFile(from, to)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil && move {
+			err = os.Remove(from)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(base + nmealogger.MetadataSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	metadata, err := nmealogger.ParseLogMetadata(data)
+	if err != nil {
+		return err
+	}
+	// The times are shifted by the corrections of the first and the last
+	// entry, which also cover the rejects logged in between
+	last := len(entries) - 1
+	metadata.Start = metadata.Start.Add(correction.Correct(0, entries[0].Time).Sub(entries[0].Time)).UTC()
+	metadata.End = metadata.End.Add(correction.Correct(last, entries[last].Time).Sub(entries[last].Time)).UTC()
+	metadata.File = filepath.Base(outputBase) + strings.TrimPrefix(metadata.File, nmealogger.LogBaseName(metadata.File))
+	if err := metadata.Write(outputBase + nmealogger.MetadataSuffix); err != nil {
+		return err
+	}
+	if move && filepath.Clean(base+nmealogger.MetadataSuffix) != outputBase+nmealogger.MetadataSuffix {
+		return os.Remove(base + nmealogger.MetadataSuffix)
+	}
+	return nil
+}
+
+// copyFile copies the file with its permissions, failing if the target
+// exists.
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
+
+func readLog(fileName string) ([]logLine, []nmealogger.LogEntry, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var lines []logLine
+	var entries []nmealogger.LogEntry
+	unparsed := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		t, sentence, err := nmealogger.ParseLogEntry(line)
+		if err != nil {
+			lines = append(lines, logLine{raw: line, entry: -1})
+			unparsed++
+			continue
+		}
+
+		lines = append(lines, logLine{entry: len(entries)})
+		entries = append(entries, nmealogger.LogEntry{Time: t, Sentence: sentence})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading: %w", err)
+	}
+
+	if unparsed > 0 {
+		log.Printf("%s: %d lines could not be parsed, copying them as is", fileName, unparsed)
+	}
+
+	return lines, entries, nil
+}
+
+// writeLog writes the corrected log to a temporary file first, so that the
+// original is only replaced once the correction is complete. An existing file
+// is only replaced if replace is set, otherwise it's an error. The file gets
+// the permissions of the original.
+func writeLog(fileName string, perm fs.FileMode, replace bool, lines []logLine, entries []nmealogger.LogEntry, correction *nmealogger.TimeCorrection) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), ".logtimefix-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	// Temporary files are only readable by the owner
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	for _, line := range lines {
+		if line.entry < 0 {
+			fmt.Fprintln(writer, line.raw)
+			continue
+		}
+
+		entry := entries[line.entry]
+		fmt.Fprintln(writer, nmealogger.FormatLogEntry(correction.Correct(line.entry, entry.Time), entry.Sentence))
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error writing: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("error writing: %w", err)
+	}
+
+	if replace {
+		return os.Rename(tmpFile.Name(), fileName)
+	}
+	// Unlike rename, linking fails if the file exists
+	if err := os.Link(tmpFile.Name(), fileName); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s already exists", fileName)
+		}
+		return err
+	}
+	return nil
+}
+
+// correctedFileName replaces the timestamp in the file name with the
+// corrected time of the first entry.
+func correctedFileName(baseName string, firstTime time.Time) string {
+	match := fileNameTimestamp.FindStringSubmatch(baseName)
+	if match == nil {
+		return baseName
+	}
+
+	return match[1] + firstTime.UTC().Format(nmealogger.LogFileTimeFormat) + match[3]
+}
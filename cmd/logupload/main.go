@@ -2,48 +2,68 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/option"
+	nmealogger "github.com/mpihlak/go-nmealogger"
+	"github.com/mpihlak/go-nmealogger/storage"
 )
 
 const (
-	FileAgeCutOffMinutes = 10
+	// Retry delays grow exponentially from min to max
+	RetryMinDelay = 5 * time.Second
+	RetryMaxDelay = 2 * time.Minute
 )
 
 func main() {
-	logDirectory := flag.String("logDir", "data", "Directory where log files are stored")
-	credentialsFile := flag.String("credentials", "nmealogger-5cf95ba688f5.json", "Location of Google Drive client credentials")
-	parentFolderID := flag.String("folderId", "1Jes5cUmB_MMk4U2qkC7SiJCeT_jBFV0Y", "ID of the upload folder in Google Drive")
-	dontRenameFiles := flag.Bool("dontRenameFiles", false, "Don't rename the uploaded log files to .uploaded")
-	flag.Parse()
-
-	entries, err := os.ReadDir(*logDirectory)
+	cfg := nmealogger.DefaultConfig()
+	c := &cfg.LogUpload
+	flag.StringVar(&c.LogDir, "logDir", c.LogDir, "Directory where log files are stored")
+	flag.StringVar(&cfg.Storage.Backend, "storage", cfg.Storage.Backend, "Where to upload the logs: drive, s3, webdav, sftp or dir")
+	flag.StringVar(&cfg.Storage.Dir, "storageDir", cfg.Storage.Dir, "Directory to upload to with -storage dir")
+	flag.StringVar(&cfg.Drive.Credentials, "credentials", cfg.Drive.Credentials, "Location of Google Drive client credentials")
+	flag.StringVar(&cfg.Drive.FolderID, "folderId", cfg.Drive.FolderID, "ID of the upload folder in Google Drive")
+	flag.BoolVar(&c.DontRenameFiles, "dontRenameFiles", c.DontRenameFiles, "Don't rename the uploaded log files to .uploaded")
+	flag.DurationVar(&c.FileAgeCutOff.Duration, "fileAgeCutOff", c.FileAgeCutOff.Duration, "Only upload files that haven't been modified within this time")
+	flag.IntVar(&c.Retries, "retries", c.Retries, "Retry uploads that fail with a transient error this many times")
+	nmealogger.ParseConfig(cfg, func() error {
+		return errors.Join(c.Validate(), cfg.ValidateStorage())
+	})
+
+	entries, err := os.ReadDir(c.LogDir)
 	if err != nil {
 		log.Fatalf("Error reading log directory: %v", err)
 	}
 
+	state, err := loadUploadState(filepath.Join(c.LogDir, StateFileName))
+	if err != nil {
+		log.Fatalf("Error reading upload state: %v", err)
+	}
+
 	ctx := context.Background()
-	srv, err := drive.NewService(ctx, option.WithCredentialsFile(*credentialsFile), option.WithScopes(drive.DriveScope))
+	store, err := storage.New(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Warning: Unable to create drive Client %v", err)
+		log.Fatalf("Error opening storage: %v", err)
 	}
+	defer store.Close()
+	u := &uploader{store: store, state: state, retries: c.Retries}
 
-	log.Printf("Looking at files in %s", *logDirectory)
+	log.Printf("Uploading files in %s to %s", c.LogDir, store)
 	filesUploaded := 0
 	uploadErrors := 0
+	pending := make(map[string]bool)
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
-		if !strings.HasSuffix(e.Name(), ".log") {
+		if !isUploadedFile(e.Name()) {
 			continue
 		}
 
@@ -53,48 +73,133 @@ func main() {
 			continue
 		}
 
-		if fileInfo.ModTime().After(time.Now().Add(-FileAgeCutOffMinutes * time.Minute)) {
-			log.Printf("File is newer than %d minutes, skipping: %s", FileAgeCutOffMinutes, e.Name())
+		if fileInfo.ModTime().After(time.Now().Add(-c.FileAgeCutOff.Duration)) {
+			log.Printf("File is newer than %v, skipping: %s", c.FileAgeCutOff, e.Name())
 			continue
 		}
 
-		pathName := filepath.Join(*logDirectory, e.Name())
-		if err := uploadFile(srv, *parentFolderID, pathName); err != nil {
-			log.Printf("Error uploading file to Drive: %v", err)
+		pathName := filepath.Join(c.LogDir, e.Name())
+		if err := u.upload(ctx, pathName, fileInfo); err != nil {
+			log.Printf("Error uploading file: %v", err)
 			uploadErrors++
+			pending[e.Name()] = true
 		} else {
 			filesUploaded++
-			if !*dontRenameFiles {
+			if !c.DontRenameFiles {
 				if err := os.Rename(pathName, pathName+".uploaded"); err != nil {
 					log.Printf("Error renaming file %s: %v", pathName, err)
 				}
 			}
 		}
 	}
+	state.prune(pending)
+	if err := state.save(); err != nil {
+		log.Printf("Error saving upload state: %v", err)
+	}
 	log.Printf("Done, %d files uploaded, %d errors.", filesUploaded, uploadErrors)
 }
 
-func uploadFile(srv *drive.Service, parentFolder string, fileName string) error {
-	log.Printf("Uploading %s", fileName)
+// isUploadedFile reports whether the file is a log or the rejects, events or
+// metadata of one.
+func isUploadedFile(name string) bool {
+	for _, suffix := range []string{".log", ".jsonl", ".rejects", ".events", nmealogger.MetadataSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploader uploads the files, resuming the uploads of earlier runs if the
+// storage supports it.
+type uploader struct {
+	store   storage.Storage
+	state   *uploadState
+	retries int
+}
+
+// upload uploads the file and checks that the stored file has the same MD5
+// checksum. Transient errors and checksum mismatches are retried with a
+// backoff. The attempts and the upload session are saved in the state after
+// each attempt.
+func (u *uploader) upload(ctx context.Context, fileName string, fileInfo fs.FileInfo) error {
+	name := filepath.Base(fileName)
+	md5, err := fileChecksum(fileName)
+	if err != nil {
+		return err
+	}
+	f := u.state.file(name, fileInfo)
+	backoff := nmealogger.NewBackoff(RetryMinDelay, RetryMaxDelay)
+	for retry := 0; ; retry++ {
+		f.Attempts++
+		f.LastAttempt = time.Now()
+		if f.Session != "" {
+			log.Printf("Resuming upload of %s, attempt %d", fileName, f.Attempts)
+		} else {
+			log.Printf("Uploading %s, attempt %d", fileName, f.Attempts)
+		}
+
+		err := u.uploadFile(ctx, fileName, f)
+		if err == nil {
+			err = storage.Verify(ctx, u.store, name, md5)
+			if errors.Is(err, storage.ErrChecksumMismatch) {
+				// The upload is complete as far as the storage knows, start
+				// over instead of resuming it
+				f.Session = ""
+			}
+		}
+		if err == nil {
+			delete(u.state.Files, name)
+		} else {
+			f.LastError = err.Error()
+		}
+		if saveErr := u.state.save(); saveErr != nil {
+			log.Printf("Error saving upload state: %v", saveErr)
+		}
+		retryable := storage.IsTransient(err) || errors.Is(err, storage.ErrChecksumMismatch)
+		if err == nil || !retryable || retry >= u.retries {
+			return err
+		}
+
+		delay := backoff.Next()
+		log.Printf("Error uploading %s: %v, retrying in %v", name, err, delay.Round(time.Second))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
 
+func (u *uploader) uploadFile(ctx context.Context, fileName string, f *fileState) error {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return fmt.Errorf("error opening file for reading: %w", err)
 	}
 	defer file.Close()
 
-	baseName := filepath.Base(fileName)
-
-	driveFile := &drive.File{
-		Name:    baseName,
-		Parents: []string{parentFolder},
+	name := filepath.Base(fileName)
+	resumable, ok := u.store.(storage.Resumable)
+	if !ok {
+		return u.store.Upload(ctx, name, file, f.Size)
 	}
+	return resumable.ResumeUpload(ctx, name, file, f.Size, f.Session, func(session string) error {
+		f.Session = session
+		return u.state.save()
+	})
+}
 
-	_, err = srv.Files.
-		Create(driveFile).
-		Media(file).
-		ProgressUpdater(func(now, size int64) { fmt.Printf("%d, %d\r", now, size) }).
-		Do()
+// fileChecksum returns the hex encoded MD5 checksum of the local file.
+func fileChecksum(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", fmt.Errorf("error opening file for reading: %w", err)
+	}
+	defer file.Close()
 
-	return err
+	md5, err := storage.Checksum(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading file: %w", err)
+	}
+	return md5, nil
 }
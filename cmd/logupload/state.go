@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The upload state is kept in the log directory
+const StateFileName = ".logupload-state.json"
+
+// uploadState tracks the files whose upload hasn't completed yet, across
+// runs. Files are removed from it once uploaded.
+type uploadState struct {
+	path  string
+	Files map[string]*fileState `json:"files"`
+}
+
+type fileState struct {
+	// The local file, a session is only resumed for the same file
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// The resumable upload session of the storage backend
+	Session     string    `json:"session,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// loadUploadState reads the state file. A missing file is an empty state.
+func loadUploadState(path string) (*uploadState, error) {
+	state := &uploadState{path: path, Files: make(map[string]*fileState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*fileState)
+	}
+	return state, nil
+}
+
+// file returns the state of the file, starting over if it has changed.
+func (s *uploadState) file(name string, info fs.FileInfo) *fileState {
+	f := s.Files[name]
+	if f == nil || f.Size != info.Size() || !f.ModTime.Equal(info.ModTime()) {
+		f = &fileState{Size: info.Size(), ModTime: info.ModTime()}
+		s.Files[name] = f
+	}
+	return f
+}
+
+// prune removes the files that aren't waiting to be uploaded anymore.
+func (s *uploadState) prune(pending map[string]bool) {
+	for name := range s.Files {
+		if !pending[name] {
+			delete(s.Files, name)
+		}
+	}
+}
+
+// save writes the state file, replacing it atomically. The sessions can be
+// URLs that give access to the upload, so the file is only readable by the
+// owner.
+func (s *uploadState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	// Report a jump if the system clock moves this much more or less than the
+	// monotonic clock between two sentences
+	ClockJumpThreshold = 1 * time.Second
+	// Report the system clock offset from GPS time again if it changes by more
+	// than this. Should be larger than the GPS time resolution.
+	ClockOffsetThreshold = 2 * time.Second
+
+	TimeSourceSystem = "system"
+	TimeSourceGPS    = "gps"
+)
+
+var (
+	clockOffset = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nmealogger_clock_offset_seconds",
+		Help: "Difference between the system clock and GPS time at the last fix.",
+	})
+	clockJumps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nmealogger_clock_jumps_total",
+		Help: "Number of detected clock jumps, by clock.",
+	}, []string{"clock"})
+)
+
+// Clock provides the timestamps for the log entries, from either the system
+// clock or the GPS disciplined clock. It also detects jumps of the system
+// clock, both by comparing it against the monotonic clock and against the
+// time received from GPS.
+type Clock struct {
+	useGPS         bool
+	gps            nmealogger.GPSClock
+	lastCheck      time.Time
+	reportedOffset *time.Duration
+}
+
+func NewClock(timeSource string) *Clock {
+	return &Clock{
+		useGPS:    timeSource == TimeSourceGPS,
+		lastCheck: time.Now(),
+	}
+}
+
+// Now returns the time for timestamping log entries.
+func (c *Clock) Now() time.Time {
+	if c.useGPS {
+		now, _ := c.gps.Now()
+		return now
+	}
+	return time.Now()
+}
+
+// Observe checks the system clock against the monotonic clock and updates
+// the GPS clock from the sentence. Returns descriptions of the detected clock
+// jumps and whether the log timestamps were stepped so that the log file
+// should be rotated to get a correct name.
+func (c *Clock) Observe(sentence string, received time.Time) (annotations []string, stepped bool) {
+	jump := received.Round(0).Sub(c.lastCheck.Round(0)) - received.Sub(c.lastCheck)
+	c.lastCheck = received
+	if jump.Abs() > ClockJumpThreshold {
+		clockJumps.WithLabelValues(TimeSourceSystem).Inc()
+		annotations = append(annotations, fmt.Sprintf("system clock jumped by %v", jump.Round(time.Millisecond)))
+		stepped = !c.useGPS
+	}
+
+	gpsTime, ok := nmealogger.ParseGPSTime(sentence)
+	if !ok {
+		return annotations, stepped
+	}
+
+	offset := received.Round(0).Sub(gpsTime)
+	clockOffset.Set(offset.Seconds())
+	if c.reportedOffset == nil || (offset-*c.reportedOffset).Abs() > ClockOffsetThreshold {
+		annotations = append(annotations, fmt.Sprintf("system clock is %v ahead of GPS time %s",
+			offset.Round(time.Millisecond), gpsTime.Format(time.RFC3339)))
+		c.reportedOffset = &offset
+	}
+
+	_, synced := c.gps.Now()
+	adjustment := c.gps.Sync(gpsTime, received)
+	if !synced {
+		annotations = append(annotations, fmt.Sprintf("GPS clock synced to %s", gpsTime.Format(time.RFC3339)))
+		stepped = stepped || (c.useGPS && offset.Abs() > ClockOffsetThreshold)
+	} else if adjustment.Abs() > ClockOffsetThreshold {
+		clockJumps.WithLabelValues(TimeSourceGPS).Inc()
+		annotations = append(annotations, fmt.Sprintf("GPS clock stepped by %v", adjustment.Round(time.Millisecond)))
+		stepped = stepped || c.useGPS
+	}
+
+	return annotations, stepped
+}
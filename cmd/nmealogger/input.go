@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	InputKplex = "kplex"
+	InputGpsd  = "gpsd"
+)
+
+// SentenceReader reads NMEA sentences from the input connection.
+type SentenceReader interface {
+	ReadSentence() (string, error)
+}
+
+// lineReader reads sentences from a plain NMEA stream such as kplex.
+type lineReader struct {
+	reader *bufio.Reader
+}
+
+func (r *lineReader) ReadSentence() (string, error) {
+	data, err := r.reader.ReadString('\n')
+	return strings.TrimRight(data, "\r\n"), err
+}
+
+// newSentenceReader sets up reading sentences from the input on the
+// connection. gpsd has to be asked to start streaming.
+func newSentenceReader(input string, conn net.Conn) (SentenceReader, error) {
+	if input != InputGpsd {
+		return &lineReader{bufio.NewReader(conn)}, nil
+	}
+
+	if _, err := conn.Write([]byte(nmealogger.GpsdWatchCommand)); err != nil {
+		return nil, fmt.Errorf("error sending watch command to gpsd: %w", err)
+	}
+	return nmealogger.NewGpsdReader(conn), nil
+}
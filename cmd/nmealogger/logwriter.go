@@ -1,69 +1,260 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	LogFormatRaw   = "raw"
+	LogFormatJSONL = "jsonl"
+	LogFormatBoth  = "both"
 )
 
+// NMEALogWriter writes the sentences to log files that are rotated at the
+// given interval. Depending on the format the sentences are written to a .log
+// file, a .jsonl file with the decoded fields or both. Rejected sentences go
+// to a .rejects file next to the log, stream dropout events to an .events file
+// and a .meta.json summary of the file is written when it's closed.
 type NMEALogWriter struct {
 	lastRotationTime     time.Time
 	fileRotationInterval time.Duration
 	outputDirectory      string
+	writeRaw             bool
+	writeJSON            bool
+	source               string
 	writer               io.WriteCloser
+	jsonWriter           io.WriteCloser
+	rejectsWriter        io.WriteCloser
+	eventsWriter         io.WriteCloser
+	metadata             *nmealogger.LogMetadata
+	basePath             string
+	currentFile          string
+	now                  func() time.Time
 }
 
-func NewNMEALogWriter(outputDirectory string, fileRotationInterval time.Duration) *NMEALogWriter {
+// NewNMEALogWriter returns a writer that timestamps entries and names the
+// log files using the now function. The source is recorded in the JSON
+// entries as where the sentences were received from.
+func NewNMEALogWriter(outputDirectory string, fileRotationInterval time.Duration, format string, source string, now func() time.Time) *NMEALogWriter {
 	return &NMEALogWriter{
 		lastRotationTime:     time.Now(),
 		fileRotationInterval: fileRotationInterval,
 		outputDirectory:      outputDirectory,
+		writeRaw:             format != LogFormatJSONL,
+		writeJSON:            format != LogFormatRaw,
+		source:               source,
 		writer:               nil,
+		now:                  now,
+	}
+}
+
+// Write logs the sentence with the timestamp now and returns the number of
+// bytes written to the log files.
+func (lw *NMEALogWriter) Write(now time.Time, sentence string) (int, error) {
+	bytes := 0
+
+	if lw.writeRaw {
+		writer, err := lw.getWriter()
+		if err != nil {
+			return 0, err
+		}
+
+		entry := nmealogger.FormatLogEntry(now, sentence) + "\n"
+		n, err := writer.Write([]byte(entry))
+		bytes += n
+		if err != nil {
+			return bytes, err
+		}
+	}
+
+	if lw.writeJSON {
+		writer, err := lw.getJSONWriter()
+		if err != nil {
+			return bytes, err
+		}
+
+		entry, err := json.Marshal(nmealogger.NewJSONLogEntry(now, lw.source, sentence))
+		if err != nil {
+			return bytes, fmt.Errorf("error encoding JSON log entry: %w", err)
+		}
+		n, err := writer.Write(append(entry, '\n'))
+		bytes += n
+		if err != nil {
+			return bytes, err
+		}
 	}
+
+	lw.metadata.AddSentence(now, sentence)
+	return bytes, nil
 }
 
-func (lw *NMEALogWriter) Write(sentence string) error {
-	writer, err := lw.getWriter()
+// Annotate writes a comment line to the log.
+func (lw *NMEALogWriter) Annotate(comment string) (int, error) {
+	return lw.Write(lw.now(), nmealogger.LogCommentPrefix+" "+comment)
+}
+
+// WriteReject writes a rejected line to the rejects file along with the
+// reason it was rejected. Lines with non-printable characters are quoted.
+func (lw *NMEALogWriter) WriteReject(line string, reason string) error {
+	writer, err := lw.getRejectsWriter()
 	if err != nil {
 		return err
 	}
 
-	// TODO: Make time format a const
-	entry := fmt.Sprintf("%s\t%s\n", time.Now().UTC().Format("2006-01-02T15:04:05.999-0700"), sentence)
-	_, err = writer.Write([]byte(entry))
+	if reason == nmealogger.ErrInvalidCharacters.Reason {
+		line = strconv.QuoteToASCII(line)
+	}
+	now := lw.now()
+	entry := nmealogger.FormatLogEntry(now, reason+"\t"+line) + "\n"
+	if _, err := writer.Write([]byte(entry)); err != nil {
+		return err
+	}
+
+	lw.metadata.AddReject(now)
+	return nil
+}
 
+// WriteEvent writes a stream dropout or recovery event to the events file.
+func (lw *NMEALogWriter) WriteEvent(event nmealogger.StreamEvent) error {
+	writer, err := lw.getEventsWriter()
+	if err != nil {
+		return err
+	}
+
+	entry := nmealogger.FormatLogEntry(lw.now(), event.String()) + "\n"
+	_, err = writer.Write([]byte(entry))
 	return err
 }
 
+// CurrentFile returns the path of the file currently being written to, the
+// .log file if both formats are written.
+func (lw *NMEALogWriter) CurrentFile() string {
+	return lw.currentFile
+}
+
+// Rotate closes the current files so that the next write starts new ones.
+func (lw *NMEALogWriter) Rotate() {
+	lw.Close()
+	lw.lastRotationTime = time.Now()
+}
+
 func (lw *NMEALogWriter) Close() {
-	if lw.writer != nil {
-		if err := lw.writer.Close(); err != nil {
-			log.Printf("Error closing active file: %v", err)
+	if lw.metadata != nil {
+		metadataFile := lw.basePath + nmealogger.MetadataSuffix
+		if err := lw.metadata.Write(metadataFile); err != nil {
+			log.Printf("Error writing %s: %v", metadataFile, err)
+		}
+		lw.metadata = nil
+	}
+
+	for _, writer := range []*io.WriteCloser{&lw.writer, &lw.jsonWriter, &lw.rejectsWriter, &lw.eventsWriter} {
+		if *writer != nil {
+			if err := (*writer).Close(); err != nil {
+				log.Printf("Error closing active file: %v", err)
+			}
+			*writer = nil
 		}
-		lw.writer = nil
 	}
+	lw.basePath = ""
 }
 
-func (lw *NMEALogWriter) getWriter() (io.Writer, error) {
+// getBasePath returns the path of the current files without the extension.
+func (lw *NMEALogWriter) getBasePath() string {
 	if time.Since(lw.lastRotationTime) > lw.fileRotationInterval {
-		lw.Close()
-		lw.lastRotationTime = time.Now()
+		lw.Rotate()
+	}
+
+	if lw.basePath == "" {
+		fileName := fmt.Sprintf("nmea-%s", lw.now().UTC().Format(nmealogger.LogFileTimeFormat))
+		lw.basePath = filepath.Join(lw.outputDirectory, fileName)
+
+		logFile := fileName + ".log"
+		if !lw.writeRaw {
+			logFile = fileName + ".jsonl"
+		}
+		lw.metadata = nmealogger.NewLogMetadata(logFile)
 	}
 
+	return lw.basePath
+}
+
+func (lw *NMEALogWriter) getWriter() (io.Writer, error) {
+	basePath := lw.getBasePath()
+
 	if lw.writer == nil {
-		fileName := fmt.Sprintf("nmea-%s.log", time.Now().UTC().Format("2006-01-02T150405"))
-		pathName := filepath.Join(lw.outputDirectory, fileName)
+		pathName := basePath + ".log"
 		log.Printf("Writing to %s", pathName)
 
-		var err error
-		lw.writer, err = os.Create(pathName)
+		file, err := os.Create(pathName)
 		if err != nil {
 			return nil, fmt.Errorf("error opening %s for writing: %w", pathName, err)
 		}
+		lw.writer = file
+		lw.currentFile = pathName
 	}
 
 	return lw.writer, nil
 }
+
+func (lw *NMEALogWriter) getJSONWriter() (io.Writer, error) {
+	basePath := lw.getBasePath()
+
+	if lw.jsonWriter == nil {
+		pathName := basePath + ".jsonl"
+		log.Printf("Writing to %s", pathName)
+
+		file, err := os.Create(pathName)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s for writing: %w", pathName, err)
+		}
+		lw.jsonWriter = file
+		if !lw.writeRaw {
+			lw.currentFile = pathName
+		}
+	}
+
+	return lw.jsonWriter, nil
+}
+
+func (lw *NMEALogWriter) getRejectsWriter() (io.Writer, error) {
+	basePath := lw.getBasePath()
+
+	if lw.rejectsWriter == nil {
+		pathName := basePath + ".rejects"
+		log.Printf("Writing rejected sentences to %s", pathName)
+
+		file, err := os.Create(pathName)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s for writing: %w", pathName, err)
+		}
+		lw.rejectsWriter = file
+	}
+
+	return lw.rejectsWriter, nil
+}
+
+func (lw *NMEALogWriter) getEventsWriter() (io.Writer, error) {
+	basePath := lw.getBasePath()
+
+	if lw.eventsWriter == nil {
+		pathName := basePath + ".events"
+		log.Printf("Writing stream events to %s", pathName)
+
+		file, err := os.Create(pathName)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s for writing: %w", pathName, err)
+		}
+		lw.eventsWriter = file
+	}
+
+	return lw.eventsWriter, nil
+}
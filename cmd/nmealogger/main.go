@@ -1,83 +1,316 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	nmealogger "github.com/mpihlak/go-nmealogger"
+	"github.com/mpihlak/go-nmealogger/sqlitesink"
 )
 
 const (
-	FileRotationInterval   = 5 * time.Minute
 	StatsReportingInterval = 60 * time.Second
+	// Reconnect delays grow exponentially from min to max while the input is unavailable
+	ReconnectMinDelay = 1 * time.Second
+	ReconnectMaxDelay = 60 * time.Second
+	// Start over from the minimum delay if the connection stayed up at least this long
+	ReconnectResetInterval = 60 * time.Second
 )
 
+// Logger reads NMEA sentences from a connection and logs them to files.
+type Logger struct {
+	config *nmealogger.NMEALoggerConfig
+	// Name and hostport of the input, kplex or gpsd
+	input     string
+	inputAddr string
+	status    *Status
+	server    *nmealogger.SentenceServer
+	watchdog  *nmealogger.Watchdog
+	clock     *Clock
+	streams   *nmealogger.StreamMonitor
+	// Optional database for the decoded sentences
+	sink *sqlitesink.Sink
+}
+
 func main() {
-	logDirectory := flag.String("logDir", "data", "Directory where log files will be stored")
-	kplex := flag.String("kplex", "127.0.0.1:10110", "Kplex server hostport")
-	flag.Parse()
+	cfg := nmealogger.DefaultConfig()
+	c := &cfg.NMEALogger
+	flag.StringVar(&c.LogDir, "logDir", c.LogDir, "Directory where log files will be stored")
+	flag.StringVar(&c.Kplex, "kplex", c.Kplex, "Kplex server hostport")
+	flag.StringVar(&c.Gpsd, "gpsd", c.Gpsd, "Read from gpsd at this hostport instead of kplex, eg. 127.0.0.1:2947")
+	flag.StringVar(&c.StatusAddr, "statusAddr", c.StatusAddr, "Serve /metrics and /status on this hostport, disabled if empty")
+	flag.StringVar(&c.ServeAddr, "serveAddr", c.ServeAddr, "Re-serve the received NMEA sentences on this hostport, disabled if empty")
+	flag.StringVar(&c.ServeFilter, "serveFilter", c.ServeFilter, "Default sentence filter for served clients, eg. RMC,IIMWV,GP")
+	flag.IntVar(&c.ServeBuffer, "serveBuffer", c.ServeBuffer, "Sentences buffered per client before a slow client is disconnected")
+	flag.DurationVar(&c.ReadTimeout.Duration, "readTimeout", c.ReadTimeout.Duration, "Reconnect if no data is received from the input within this time")
+	flag.DurationVar(&c.FileRotationInterval.Duration, "fileRotationInterval", c.FileRotationInterval.Duration, "Start a new log file after this interval")
+	flag.StringVar(&c.TimeSource, "timeSource", c.TimeSource, "Timestamp log entries with the system clock or GPS disciplined clock: system or gps")
+	flag.StringVar(&c.LogFormat, "logFormat", c.LogFormat, "Log file format: raw, jsonl for JSON Lines with decoded fields, or both")
+	flag.StringVar(&c.SQLiteDB, "sqliteDB", c.SQLiteDB, "Also store the decoded sentences in this SQLite database, disabled if empty")
+	flag.DurationVar(&c.SQLiteRetention.Duration, "sqliteRetention", c.SQLiteRetention.Duration, "Delete data older than this from the database, 0 keeps everything")
+	nmealogger.ParseConfig(cfg, c.Validate)
 
-	log.Printf("Starting NMEA logger: log directory = %s, kplex = %s", *logDirectory, *kplex)
+	input, inputAddr := InputKplex, c.Kplex
+	if c.Gpsd != "" {
+		input, inputAddr = InputGpsd, c.Gpsd
+	}
+	log.Printf("Starting NMEA logger: log directory = %s, %s = %s", c.LogDir, input, inputAddr)
 
-	if err := os.MkdirAll(*logDirectory, os.ModePerm); err != nil {
+	if err := os.MkdirAll(c.LogDir, os.ModePerm); err != nil {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
-	for {
-		conn, err := net.Dial("tcp", *kplex)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logger := &Logger{
+		config:    c,
+		input:     input,
+		inputAddr: inputAddr,
+		status:    NewStatus(inputAddr),
+		watchdog:  nmealogger.NewWatchdog(),
+		clock:     NewClock(c.TimeSource),
+		streams:   nmealogger.NewStreamMonitor(),
+	}
+
+	if c.StatusAddr != "" {
+		logger.status.Serve(c.StatusAddr)
+	}
+
+	if c.SQLiteDB != "" {
+		sink, err := sqlitesink.Open(c.SQLiteDB, c.SQLiteRetention.Duration)
 		if err != nil {
-			log.Printf("Error connecting to kplex: %v", err)
-			log.Printf("Retrying ...")
-			time.Sleep(1 * time.Second)
+			log.Fatalf("Error opening database: %v", err)
+		}
+		log.Printf("Storing decoded sentences in %s", c.SQLiteDB)
+		logger.sink = sink
+		defer sink.Close()
+	}
+
+	if c.ServeAddr != "" {
+		listener, err := net.Listen("tcp", c.ServeAddr)
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", c.ServeAddr, err)
+		}
+		log.Printf("Serving NMEA sentences on %s", c.ServeAddr)
+
+		logger.server = nmealogger.NewSentenceServer(listener, c.ServeBuffer, nmealogger.ParseSentenceFilter(c.ServeFilter))
+		logger.status.RegisterServer(logger.server)
+		go func() {
+			if err := logger.server.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Fatalf("Error accepting connections: %v", err)
+			}
+		}()
+		defer logger.server.Close()
+	}
+
+	go logger.watchdog.Run(ctx)
+
+	// Ready once initialized rather than once connected, so that systemd
+	// doesn't time out the start while the input is unreachable. The watchdog
+	// restarts the service if no data arrives.
+	if err := nmealogger.SdNotify("READY=1"); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	}
+
+	backoff := nmealogger.NewBackoff(ReconnectMinDelay, ReconnectMaxDelay)
+	for ctx.Err() == nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", inputAddr)
+		if err != nil {
+			delay := backoff.Next()
+			log.Printf("Error connecting to %s: %v", input, err)
+			log.Printf("Retrying in %v ...", delay.Round(time.Millisecond))
+			sleep(ctx, delay)
 			continue
 		}
 
-		log.Printf("Connected to kplex, start processing messages")
-		processMessages(conn, *logDirectory)
+		log.Printf("Connected to %s, start processing messages", input)
+
+		connectedAt := time.Now()
+		logger.status.SetConnected(true)
+		logger.processMessages(ctx, conn)
+		logger.status.SetConnected(false)
+
+		if time.Since(connectedAt) > ReconnectResetInterval {
+			backoff.Reset()
+		}
+		if ctx.Err() == nil {
+			delay := backoff.Next()
+			log.Printf("Reconnecting in %v ...", delay.Round(time.Millisecond))
+			sleep(ctx, delay)
+		}
+	}
+
+	log.Printf("Shutting down")
+	nmealogger.SdNotify("STOPPING=1")
+}
+
+// formatCounts formats the counts as "a: 1, b: 2" in the order of the keys.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %d", key, counts[key]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sleep waits for the duration or until the context is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
 	}
 }
 
-func processMessages(conn net.Conn, outputDirectory string) {
-	reader := bufio.NewReader(conn)
+func (l *Logger) processMessages(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	reader, err := newSentenceReader(l.input, conn)
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-	logWriter := NewNMEALogWriter(outputDirectory, FileRotationInterval)
+	logWriter := NewNMEALogWriter(l.config.LogDir, l.config.FileRotationInterval.Duration, l.config.LogFormat, l.inputAddr, l.clock.Now)
 	defer logWriter.Close()
 
+	// The database is otherwise only committed when the next sentence arrives,
+	// so commit when the input goes quiet and the read times out
+	if l.sink != nil {
+		defer func() {
+			if err := l.sink.Commit(); err != nil {
+				log.Printf("Error writing to database: %v", err)
+			}
+		}()
+	}
+
+	// Unblock the read on shutdown so that the log file is closed properly
+	stopRead := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopRead()
+
+	l.streams.Restart(time.Now())
+
 	statsLastReported := time.Now()
 	messagesProcessed := 0
 	messagesSkipped := 0
+	rejectReasons := make(map[string]int)
+	lastRejected := ""
 
 	for {
 		if time.Since(statsLastReported) > StatsReportingInterval {
 			log.Printf("%d sentences logged, %d skipped", messagesProcessed, messagesSkipped)
+			if messagesSkipped > 0 {
+				log.Printf("Skipped sentences by reason: %s, last skipped: %q", formatCounts(rejectReasons), lastRejected)
+			}
 			statsLastReported = time.Now()
 			messagesProcessed = 0
 			messagesSkipped = 0
+			clear(rejectReasons)
 		}
 
-		data, err := reader.ReadString('\n')
+		if err := conn.SetReadDeadline(time.Now().Add(l.config.ReadTimeout.Duration)); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error setting read deadline: %v", err)
+			}
+			return
+		}
+
+		sentence, err := reader.ReadSentence()
 		if err != nil {
-			log.Printf("Error reading from kplex: %v", err)
+			if ctx.Err() == nil {
+				log.Printf("Error reading from %s: %v", l.input, err)
+				// Report the streams that went silent with the input, as
+				// they are only checked when sentences arrive otherwise
+				if err := l.writeStreamEvents(logWriter, l.streams.Check(time.Now())); err != nil {
+					log.Printf("Error writing stream event: %v", err)
+				}
+			}
 			return
 		}
+		received := time.Now()
+		l.watchdog.Activity()
 
-		sentence := strings.TrimRight(string(data), "\r\n")
-		if !nmealogger.HasValidChecksum(sentence) {
-			log.Printf("Skipping sentence with invalid checksum: [%s]", sentence)
+		if err := nmealogger.ValidateSentence(sentence); err != nil {
+			reason := err.(*nmealogger.SentenceError).Reason
 			messagesSkipped += 1
+			rejectReasons[reason] += 1
+			lastRejected = sentence
+			l.status.SentenceRejected(reason)
+
+			if err := logWriter.WriteReject(sentence, reason); err != nil {
+				log.Printf("Error writing rejected sentence: %v", err)
+				return
+			}
 			continue
 		}
 
-		if err := logWriter.Write(sentence); err != nil {
+		annotations, stepped := l.clock.Observe(sentence, received)
+		if stepped {
+			logWriter.Rotate()
+		}
+		for _, annotation := range annotations {
+			log.Printf("Clock: %s", annotation)
+			if _, err := logWriter.Annotate(annotation); err != nil {
+				log.Printf("Error writing log entry: %v", err)
+				return
+			}
+		}
+
+		events := l.streams.Observe(sentence, received)
+		events = append(events, l.streams.Check(received)...)
+		if err := l.writeStreamEvents(logWriter, events); err != nil {
+			log.Printf("Error writing stream event: %v", err)
+			return
+		}
+
+		// The same timestamp for the log and the database, the clock can step
+		// in between
+		now := l.clock.Now()
+		bytes, err := logWriter.Write(now, sentence)
+		if err != nil {
 			log.Printf("Error writing log entry: %v", err)
 			return
 		}
+		l.status.SentenceLogged(sentence, logWriter.CurrentFile(), bytes)
+
+		if l.sink != nil {
+			if err := l.sink.WriteSentence(now, sentence); err != nil {
+				log.Printf("Error writing to database: %v", err)
+			}
+		}
+
+		if l.server != nil {
+			l.server.Broadcast(sentence)
+		}
 
 		messagesProcessed += 1
 	}
 }
+
+// writeStreamEvents logs the stream events and writes them to the events file.
+func (l *Logger) writeStreamEvents(logWriter *NMEALogWriter, events []nmealogger.StreamEvent) error {
+	for _, event := range events {
+		log.Printf("Stream %s", event)
+		l.status.StreamEvent(event)
+		if err := logWriter.WriteEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+var (
+	sentencesLogged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nmealogger_sentences_total",
+		Help: "Number of NMEA sentences logged, by talker and sentence type.",
+	}, []string{"talker", "type"})
+	sentencesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nmealogger_rejected_sentences_total",
+		Help: "Number of NMEA sentences rejected, by reason.",
+	}, []string{"reason"})
+	bytesWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nmealogger_bytes_written_total",
+		Help: "Number of bytes written to log files.",
+	})
+	currentFile = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nmealogger_current_file_info",
+		Help: "The log file currently being written to.",
+	}, []string{"file"})
+	connected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nmealogger_connected",
+		Help: "Whether the logger is connected to the NMEA server.",
+	})
+	streamDown = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nmealogger_stream_down",
+		Help: "Whether the sentence stream has dropped out or its rate has collapsed, by talker and sentence type.",
+	}, []string{"stream"})
+	streamEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nmealogger_stream_events_total",
+		Help: "Number of sentence stream dropout, degraded and recovered events, by stream and event.",
+	}, []string{"stream", "event"})
+)
+
+// Status keeps track of the logger state for the /status page and the
+// metrics that are not simple counters.
+type Status struct {
+	mu                sync.Mutex
+	startTime         time.Time
+	server            string
+	connected         bool
+	currentFile       string
+	lastSentenceTime  time.Time
+	sentencesLogged   int
+	sentencesRejected int
+	bytesWritten      int
+	sentenceServer    *nmealogger.SentenceServer
+	// Streams in a dropout or degraded, by talker and sentence type
+	streamsDown map[string]string
+}
+
+func NewStatus(server string) *Status {
+	s := &Status{
+		startTime:   time.Now(),
+		server:      server,
+		streamsDown: make(map[string]string),
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nmealogger_last_sentence_age_seconds",
+		Help: "Seconds since the last NMEA sentence was logged.",
+	}, func() float64 {
+		return s.lastSentenceAge().Seconds()
+	})
+
+	return s
+}
+
+// RegisterServer adds the sentence server client counts to metrics and status.
+func (s *Status) RegisterServer(server *nmealogger.SentenceServer) {
+	s.mu.Lock()
+	s.sentenceServer = server
+	s.mu.Unlock()
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nmealogger_server_clients",
+		Help: "Number of clients connected to the sentence server.",
+	}, func() float64 {
+		return float64(server.NumClients())
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "nmealogger_server_dropped_clients_total",
+		Help: "Number of clients disconnected for being too slow.",
+	}, func() float64 {
+		return float64(server.DroppedClients())
+	})
+}
+
+func (s *Status) SetConnected(isConnected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connected = isConnected
+	if isConnected {
+		connected.Set(1)
+	} else {
+		connected.Set(0)
+	}
+}
+
+func (s *Status) SentenceLogged(sentence string, fileName string, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	talker, sentenceType, _ := nmealogger.SentenceID(sentence)
+	sentencesLogged.WithLabelValues(talker, sentenceType).Inc()
+	bytesWritten.Add(float64(bytes))
+
+	if fileName != s.currentFile {
+		currentFile.Reset()
+		currentFile.WithLabelValues(fileName).Set(1)
+		s.currentFile = fileName
+	}
+
+	s.lastSentenceTime = time.Now()
+	s.sentencesLogged++
+	s.bytesWritten += bytes
+}
+
+func (s *Status) SentenceRejected(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sentencesRejected.WithLabelValues(reason).Inc()
+	s.sentencesRejected++
+}
+
+func (s *Status) StreamEvent(event nmealogger.StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	streamEvents.WithLabelValues(event.Stream, event.Event).Inc()
+	if event.Event == nmealogger.StreamRecovered {
+		streamDown.WithLabelValues(event.Stream).Set(0)
+		delete(s.streamsDown, event.Stream)
+	} else {
+		streamDown.WithLabelValues(event.Stream).Set(1)
+		s.streamsDown[event.Stream] = event.Event
+	}
+}
+
+func (s *Status) lastSentenceAge() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastSentenceTime.IsZero() {
+		return time.Since(s.startTime)
+	}
+	return time.Since(s.lastSentenceTime)
+}
+
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	age := s.lastSentenceAge()
+
+	s.mu.Lock()
+	status := struct {
+		StartTime         time.Time         `json:"startTime"`
+		Server            string            `json:"server"`
+		Connected         bool              `json:"connected"`
+		CurrentFile       string            `json:"currentFile"`
+		LastSentenceTime  *time.Time        `json:"lastSentenceTime"`
+		LastSentenceAge   float64           `json:"lastSentenceAgeSeconds"`
+		SentencesLogged   int               `json:"sentencesLogged"`
+		SentencesRejected int               `json:"sentencesRejected"`
+		BytesWritten      int               `json:"bytesWritten"`
+		ServerClients     *int              `json:"serverClients,omitempty"`
+		StreamsDown       map[string]string `json:"streamsDown"`
+	}{
+		StartTime:         s.startTime,
+		Server:            s.server,
+		Connected:         s.connected,
+		CurrentFile:       s.currentFile,
+		LastSentenceAge:   age.Seconds(),
+		SentencesLogged:   s.sentencesLogged,
+		SentencesRejected: s.sentencesRejected,
+		BytesWritten:      s.bytesWritten,
+		StreamsDown:       maps.Clone(s.streamsDown),
+	}
+	if !s.lastSentenceTime.IsZero() {
+		lastSentenceTime := s.lastSentenceTime
+		status.LastSentenceTime = &lastSentenceTime
+	}
+	if s.sentenceServer != nil {
+		clients := s.sentenceServer.NumClients()
+		status.ServerClients = &clients
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("Error writing status response: %v", err)
+	}
+}
+
+// Serve starts the HTTP server for /metrics and /status in the background.
+func (s *Status) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/status", s)
+
+	log.Printf("Serving metrics and status on %s", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("Error serving metrics and status: %v", err)
+		}
+	}()
+}
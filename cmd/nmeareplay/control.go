@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// ServeControl starts the HTTP control API in the background:
+//
+//	GET  /status                         replay time, position and clients
+//	POST /pause                          stop sending sentences
+//	POST /resume                         continue from where it was paused
+//	POST /seek?time=2006-01-02T15:04:05  continue from the first entry at or after time, UTC
+//	POST /speed?speed=2                  change the replay speed, 0 for as fast as possible
+func (r *replay) ServeControl(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", r.serveStatus)
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, req *http.Request) {
+		r.control(w, command{action: commandPause})
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, req *http.Request) {
+		r.control(w, command{action: commandResume})
+	})
+	mux.HandleFunc("POST /seek", func(w http.ResponseWriter, req *http.Request) {
+		t, err := time.Parse(nmealogger.ReplayTimeFormat, req.FormValue("time"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid time, expected format %s", nmealogger.ReplayTimeFormat), http.StatusBadRequest)
+			return
+		}
+		r.control(w, command{action: commandSeek, time: t})
+	})
+	mux.HandleFunc("POST /speed", func(w http.ResponseWriter, req *http.Request) {
+		speed, err := strconv.ParseFloat(req.FormValue("speed"), 64)
+		if err != nil || speed < 0 {
+			http.Error(w, "Invalid speed, expected a non-negative number", http.StatusBadRequest)
+			return
+		}
+		r.control(w, command{action: commandSpeed, speed: speed})
+	})
+
+	log.Printf("Serving the control API on %s", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("Error serving the control API: %v", err)
+		}
+	}()
+}
+
+// control passes the command to the replay and responds with the status
+// after it has been carried out.
+func (r *replay) control(w http.ResponseWriter, cmd command) {
+	cmd.result = make(chan error, 1)
+	r.commands <- cmd
+	if err := <-cmd.result; err != nil {
+		log.Printf("Error in replay %s: %v", describeCommand(cmd), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.serveStatus(w, nil)
+}
+
+func (r *replay) serveStatus(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	status := struct {
+		Time      *time.Time `json:"time"`
+		File      string     `json:"file"`
+		FileIndex int        `json:"fileIndex"`
+		Files     int        `json:"files"`
+		Offset    int64      `json:"offset"`
+		Speed     float64    `json:"speed"`
+		Paused    bool       `json:"paused"`
+		Finished  bool       `json:"finished"`
+		Sentences int        `json:"sentencesReplayed"`
+		Clients   int        `json:"clients"`
+	}{
+		FileIndex: r.fileIndex,
+		Files:     len(r.files),
+		Offset:    r.offset,
+		Speed:     r.speed,
+		Paused:    r.paused,
+		Finished:  r.finished,
+		Sentences: r.sentences,
+		Clients:   r.numClients(),
+	}
+	if !r.logTime.IsZero() {
+		logTime := r.logTime
+		status.Time = &logTime
+	}
+	if r.fileIndex < len(r.files) {
+		status.File = r.files[r.fileIndex]
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("Error writing status response: %v", err)
+	}
+}
+
+func describeCommand(cmd command) string {
+	switch cmd.action {
+	case commandSeek:
+		return fmt.Sprintf("seek to %s", cmd.time.Format(nmealogger.ReplayTimeFormat))
+	case commandSpeed:
+		return fmt.Sprintf("speed %g", cmd.speed)
+	default:
+		return cmd.action
+	}
+}
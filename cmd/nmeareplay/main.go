@@ -2,83 +2,127 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
-	"net"
 	"os"
-	"strings"
 	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
 )
 
-func main() {
-	inputFile := flag.String("inputFile", "", "Name of the input file")
-	optionalStartTime := flag.String("startTime", "", "Start time of replay, format 2006-01-02T15:04:05, UTC time zone")
-	flag.Parse()
+const (
+	StatsReportingInterval = 60 * time.Second
+	// Sentences buffered per client before a slow client is disconnected
+	ReplayBufferSize = 1024
+)
 
-	buf, err := os.ReadFile(*inputFile)
-	if err != nil {
-		log.Fatalf("Error reading input file: %v", err)
+func main() {
+	cfg := nmealogger.DefaultConfig()
+	c := &cfg.NMEAReplay
+	flag.StringVar(&c.InputFile, "inputFile", c.InputFile, "Log file, directory of log files or glob pattern to replay")
+	flag.StringVar(&c.StartTime, "startTime", c.StartTime, "Start time of replay, format 2006-01-02T15:04:05, UTC time zone")
+	flag.StringVar(&c.EndTime, "endTime", c.EndTime, "End time of replay, format 2006-01-02T15:04:05, UTC time zone")
+	flag.Float64Var(&c.Speed, "speed", c.Speed, "Replay speed as a multiple of real time, 0 for as fast as possible")
+	flag.DurationVar(&c.MaxGap.Duration, "maxGap", c.MaxGap.Duration, "Shorten gaps between sentences to at most this, 0 keeps them")
+	flag.BoolVar(&c.WaitForClient, "waitForClient", c.WaitForClient, "Start the replay when the first client connects")
+	flag.BoolVar(&c.RewriteTimes, "rewriteTimes", c.RewriteTimes, "Shift the times in RMC, ZDA, GGA and GLL sentences to the time of replay")
+	flag.StringVar(&c.ListenAddr, "listenAddr", c.ListenAddr, "Serve the replay to TCP clients on this hostport, disabled if empty")
+	flag.StringVar(&c.UDPAddr, "udpAddr", c.UDPAddr, "Send the sentences as UDP datagrams to this unicast or broadcast hostport")
+	flag.BoolVar(&c.Stdout, "stdout", c.Stdout, "Write the sentences to stdout")
+	flag.StringVar(&c.PTY, "pty", c.PTY, "Write the sentences to a pseudo-terminal linked to this path, eg. /tmp/nmea0")
+	flag.StringVar(&c.SignalKAddr, "signalkAddr", c.SignalKAddr, "Serve the replay as a SignalK delta stream on this hostport, disabled if empty")
+	flag.StringVar(&c.Keep, "keep", c.Keep, "Replay only the sentences matching these patterns, eg. RMC,II")
+	flag.StringVar(&c.Drop, "drop", c.Drop, "Leave out the sentences matching these patterns, eg. GP to simulate a GPS failure")
+	flag.StringVar(&c.Rename, "rename", c.Rename, "Rename talkers and sentence types, eg. II=GP,VWR=MWV")
+	flag.Float64Var(&c.DropRate, "dropRate", c.DropRate, "Fraction of the sentences to drop at random")
+	flag.Float64Var(&c.CorruptRate, "corruptRate", c.CorruptRate, "Fraction of the sentences to send with a corrupted checksum")
+	flag.Float64Var(&c.DelayRate, "delayRate", c.DelayRate, "Fraction of the sentences to send late by -delay")
+	flag.DurationVar(&c.Delay.Duration, "delay", c.Delay.Duration, "How late to send the delayed sentences")
+	flag.StringVar(&c.ControlAddr, "controlAddr", c.ControlAddr, "Serve the HTTP control API on this hostport, disabled if empty")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [logfile|directory|pattern ...]\n", os.Args[0])
+		flag.PrintDefaults()
 	}
+	nmealogger.ParseConfig(cfg, c.Validate)
 
-	var startTime time.Time
-	if *optionalStartTime != "" {
-		startTime, err = time.Parse("2006-01-02T15:04:05", *optionalStartTime)
-		if err != nil {
-			log.Fatalf("Error parsing start time: %v", err)
-		}
+	inputs := flag.Args()
+	if c.InputFile != "" {
+		inputs = append([]string{c.InputFile}, inputs...)
+	}
+	if len(inputs) == 0 {
+		log.Fatalf("No input files, use -inputFile or give them as arguments")
 	}
 
-	listenAddr := "0.0.0.0:10110"
-	log.Printf("Listening on %s", listenAddr)
-	listener, err := net.Listen("tcp", listenAddr)
+	files, err := nmealogger.ExpandLogFiles(inputs)
 	if err != nil {
-		log.Fatalf("Error listening on port: %v", err)
+		log.Fatalf("Error finding input files: %v", err)
 	}
+	if len(files) == 0 {
+		log.Fatalf("No log entries found in %v", inputs)
+	}
+	log.Printf("Replaying %d files from %s to %s", len(files), files[0], files[len(files)-1])
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Fatalf("Error accepting connection: %v", err)
-		}
-		log.Printf("Connection accepted from %v", conn.RemoteAddr())
-
-		go nmeaReplay(conn, buf, startTime)
+	// The times have been validated already
+	var startTime, endTime time.Time
+	if c.StartTime != "" {
+		startTime, _ = time.Parse(nmealogger.ReplayTimeFormat, c.StartTime)
+	}
+	if c.EndTime != "" {
+		endTime, _ = time.Parse(nmealogger.ReplayTimeFormat, c.EndTime)
 	}
-}
 
-func nmeaReplay(conn net.Conn, buf []byte, startTime time.Time) {
-	prevTime := time.Time{}
-	totalBytes := 0
-	for _, line := range strings.Split(string(buf), "\n") {
-		timestamp, sentence, ok := strings.Cut(line, "\t")
-		if !ok {
-			continue
+	replay := newReplay(files, startTime, endTime, newPacer(c.Speed, c.MaxGap.Duration))
+	if c.ListenAddr != "" {
+		tcp, err := newTCPOutput(c.ListenAddr)
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", c.ListenAddr, err)
 		}
-
-		currTime, err := time.Parse("2006-01-02T15:04:05.9+00:00", timestamp)
+		replay.tcp = tcp
+		replay.outputs = append(replay.outputs, tcp)
+	}
+	if c.UDPAddr != "" {
+		udp, err := newUDPOutput(c.UDPAddr)
 		if err != nil {
-			log.Printf("Error parsing timestamp: %v", err)
+			log.Fatalf("Error setting up UDP output to %s: %v", c.UDPAddr, err)
 		}
-
-		if startTime.After(currTime) {
-			continue
+		replay.outputs = append(replay.outputs, udp)
+	}
+	if c.Stdout {
+		replay.outputs = append(replay.outputs, newStdoutOutput())
+	}
+	if c.PTY != "" {
+		pty, err := newPTYOutput(c.PTY)
+		if err != nil {
+			log.Fatalf("Error setting up pseudo-terminal output: %v", err)
 		}
-
-		if !prevTime.IsZero() {
-			delta := currTime.Sub(prevTime)
-			time.Sleep(delta)
+		replay.outputs = append(replay.outputs, pty)
+	}
+	if c.SignalKAddr != "" {
+		signalK, err := newSignalKOutput(c.SignalKAddr)
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", c.SignalKAddr, err)
 		}
+		replay.outputs = append(replay.outputs, signalK)
+	}
+	replay.rewriteTimes = c.RewriteTimes
+	replay.rules = newRules(c)
+	if c.ControlAddr != "" {
+		replay.interactive = true
+		replay.ServeControl(c.ControlAddr)
+	}
 
-		bytes, err := conn.Write([]byte(sentence + "\n"))
-		if err != nil {
-			log.Printf("Error writing to %s: %v", conn.RemoteAddr(), err)
-			conn.Close()
-			return
+	if c.WaitForClient {
+		log.Printf("Waiting for a client to connect")
+		for replay.tcp.server.NumClients() == 0 {
+			time.Sleep(100 * time.Millisecond)
 		}
+	}
 
-		log.Printf("sent to %s: [%s] @%v", conn.RemoteAddr(), sentence, currTime)
+	if err := replay.run(); err != nil {
+		log.Printf("Error reading log: %v", err)
+	}
 
-		totalBytes += bytes
-		prevTime = currTime
+	for _, output := range replay.outputs {
+		output.Close()
 	}
-	log.Printf("%s finished, sent %d total bytes.", conn.RemoteAddr(), totalBytes)
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// How long to wait for the TCP clients to receive the remaining sentences at
+// the end of the replay
+const DrainTimeout = 5 * time.Second
+
+// output sends the replayed sentences to the clients. The time is when the
+// sentence was logged, or shifted to the time of replay with -rewriteTimes.
+// Sending must not block the replay, outputs that can't keep up drop
+// sentences or clients. The exception is the TCP output when replaying as
+// fast as possible, see replay.broadcastWait.
+type output interface {
+	Send(t time.Time, sentence string)
+	Close()
+}
+
+// tcpOutput serves the sentences to any number of TCP clients. All clients
+// share the one replay, late joiners get the current position.
+type tcpOutput struct {
+	server *nmealogger.SentenceServer
+}
+
+func newTCPOutput(addr string) (*tcpOutput, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Listening on %s", listener.Addr())
+
+	server := nmealogger.NewSentenceServer(listener, ReplayBufferSize, nil)
+	go func() {
+		if err := server.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Fatalf("Error accepting connections: %v", err)
+		}
+	}()
+
+	return &tcpOutput{server: server}, nil
+}
+
+func (o *tcpOutput) Send(t time.Time, sentence string) {
+	o.server.Broadcast(sentence)
+}
+
+// Close lets the clients receive the tail of the replay before disconnecting.
+func (o *tcpOutput) Close() {
+	o.server.Drain(DrainTimeout)
+}
+
+// udpOutput sends each sentence as a datagram to a unicast or broadcast
+// address.
+type udpOutput struct {
+	conn *net.UDPConn
+	// Only the first of consecutive errors is logged
+	failing bool
+}
+
+func newUDPOutput(addr string) (*udpOutput, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Go enables broadcast on UDP sockets, so this works for both
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Sending UDP to %s", udpAddr)
+
+	return &udpOutput{conn: conn}, nil
+}
+
+func (o *udpOutput) Send(t time.Time, sentence string) {
+	_, err := o.conn.Write([]byte(sentence + "\r\n"))
+	if err != nil && !o.failing {
+		log.Printf("Error sending UDP: %v", err)
+	}
+	o.failing = err != nil
+}
+
+func (o *udpOutput) Close() {
+	o.conn.Close()
+}
+
+// writerOutput writes the sentences to stdout or a pseudo-terminal.
+type writerOutput struct {
+	name   string
+	writer io.WriteCloser
+	// Only the first of consecutive errors is logged
+	failing bool
+}
+
+func newStdoutOutput() *writerOutput {
+	return &writerOutput{name: "stdout", writer: os.Stdout}
+}
+
+func (o *writerOutput) Send(t time.Time, sentence string) {
+	_, err := fmt.Fprintf(o.writer, "%s\r\n", sentence)
+	if err != nil && !o.failing {
+		log.Printf("Error writing to %s: %v", o.name, err)
+	}
+	o.failing = err != nil
+}
+
+func (o *writerOutput) Close() {
+	o.writer.Close()
+}
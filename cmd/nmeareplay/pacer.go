@@ -0,0 +1,94 @@
+package main
+
+import "time"
+
+// A replay that falls further behind than this, eg. because of a slow client,
+// continues from the current time instead of sending a burst to catch up
+const MaxReplayLag = time.Second
+
+// pacer schedules the entries so that they are replayed at the given speed,
+// with idle gaps shortened to maxGap. The replay position is the log time
+// with the gaps shortened. It advances from an anchor at the speed, so that
+// the errors of the individual sleeps don't add up and the speed can be
+// changed in the middle of a gap.
+type pacer struct {
+	// Multiple of real time, 0 for no waiting
+	speed float64
+	// Longest gap between entries in log time, 0 for no limit
+	maxGap time.Duration
+
+	prevLogTime time.Time
+	// Replay position of the latest entry
+	position time.Duration
+	// The replay was at anchorPosition at anchorTime
+	anchorTime     time.Time
+	anchorPosition time.Duration
+}
+
+func newPacer(speed float64, maxGap time.Duration) *pacer {
+	return &pacer{speed: speed, maxGap: maxGap}
+}
+
+// advance moves the replay position to the entry logged at logTime and
+// returns when it is due.
+func (p *pacer) advance(logTime time.Time, now time.Time) time.Time {
+	if p.prevLogTime.IsZero() {
+		p.prevLogTime = logTime
+		p.anchor(now, p.position)
+		return now
+	}
+
+	// Clock steps in the log can make the gap negative
+	gap := max(logTime.Sub(p.prevLogTime), 0)
+	p.prevLogTime = logTime
+	if p.maxGap > 0 {
+		gap = min(gap, p.maxGap)
+	}
+	p.position += gap
+
+	due := p.due()
+	if now.Sub(due) > MaxReplayLag {
+		p.anchor(now, p.position)
+		return now
+	}
+	return due
+}
+
+// due returns when the latest entry is due.
+func (p *pacer) due() time.Time {
+	if p.speed == 0 {
+		return p.anchorTime
+	}
+	return p.anchorTime.Add(time.Duration(float64(p.position-p.anchorPosition) / p.speed))
+}
+
+// current returns the replay position at now, which is at most the position
+// of the latest entry.
+func (p *pacer) current(now time.Time) time.Duration {
+	if p.speed == 0 {
+		return p.position
+	}
+	return min(p.anchorPosition+time.Duration(float64(now.Sub(p.anchorTime))*p.speed), p.position)
+}
+
+func (p *pacer) anchor(now time.Time, position time.Duration) {
+	p.anchorTime = now
+	p.anchorPosition = position
+}
+
+// setSpeed changes the speed from the current position on.
+func (p *pacer) setSpeed(speed float64, now time.Time) {
+	p.anchor(now, p.current(now))
+	p.speed = speed
+}
+
+// resume continues from the position the replay was paused at.
+func (p *pacer) resume(pausedAt time.Duration, now time.Time) {
+	p.anchor(now, pausedAt)
+}
+
+// reset starts the pacing over, eg. after a seek. The next entry is due
+// immediately.
+func (p *pacer) reset() {
+	p.prevLogTime = time.Time{}
+}
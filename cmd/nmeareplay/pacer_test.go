@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// pacerStep is an action on the pacer at now, with the times as offsets from
+// the start of the replay and the log.
+type pacerStep struct {
+	// advance, speed or resume
+	action string
+	now    time.Duration
+	// For advance, when the entry was logged
+	log time.Duration
+	// For speed, the new speed
+	speed float64
+	// For resume, the position the replay was paused at
+	pausedAt time.Duration
+
+	// When the latest entry is due and the replay position at now
+	due     time.Duration
+	current time.Duration
+}
+
+func TestPacer(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		speed  float64
+		maxGap time.Duration
+		steps  []pacerStep
+	}{
+		{
+			name:  "real time",
+			speed: 1,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: time.Second, due: time.Second, current: 0},
+				{action: "advance", now: time.Second, log: 3 * time.Second, due: 3 * time.Second, current: time.Second},
+			},
+		},
+		{
+			name:  "faster",
+			speed: 2,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: 2 * time.Second, due: time.Second, current: 0},
+				{action: "advance", now: time.Second, log: 10 * time.Second, due: 5 * time.Second, current: 2 * time.Second},
+			},
+		},
+		{
+			name:  "as fast as possible",
+			speed: 0,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: time.Hour, due: 0, current: time.Hour},
+				{action: "advance", now: time.Millisecond, log: 2 * time.Hour, due: 0, current: 2 * time.Hour},
+			},
+		},
+		{
+			name:   "gap capped",
+			speed:  1,
+			maxGap: 5 * time.Second,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: time.Hour, due: 5 * time.Second, current: 0},
+				{action: "advance", now: 5 * time.Second, log: time.Hour + time.Second, due: 6 * time.Second, current: 5 * time.Second},
+			},
+		},
+		{
+			name:  "clock stepped back",
+			speed: 1,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 10 * time.Second, due: 0, current: 0},
+				{action: "advance", now: 0, log: 5 * time.Second, due: 0, current: 0},
+				{action: "advance", now: 0, log: 6 * time.Second, due: time.Second, current: 0},
+			},
+		},
+		{
+			name:  "fallen behind",
+			speed: 1,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				// Continues from now instead of catching up
+				{action: "advance", now: 5 * time.Second, log: time.Second, due: 5 * time.Second, current: time.Second},
+				{action: "advance", now: 5 * time.Second, log: 2 * time.Second, due: 6 * time.Second, current: time.Second},
+			},
+		},
+		{
+			name:  "speed changed in a gap",
+			speed: 1,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: 10 * time.Second, due: 10 * time.Second, current: 0},
+				// The remaining 6 seconds at double speed
+				{action: "speed", now: 4 * time.Second, speed: 2, due: 7 * time.Second, current: 4 * time.Second},
+				{action: "advance", now: 7 * time.Second, log: 12 * time.Second, due: 8 * time.Second, current: 10 * time.Second},
+			},
+		},
+		{
+			name:  "speed changed to as fast as possible in a gap",
+			speed: 1,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: 10 * time.Second, due: 10 * time.Second, current: 0},
+				{action: "speed", now: 4 * time.Second, speed: 0, due: 4 * time.Second, current: 10 * time.Second},
+			},
+		},
+		{
+			name:  "resumed",
+			speed: 1,
+			steps: []pacerStep{
+				{action: "advance", now: 0, log: 0, due: 0, current: 0},
+				{action: "advance", now: 0, log: 10 * time.Second, due: 10 * time.Second, current: 0},
+				// Paused at 3s and resumed at 20s
+				{action: "resume", now: 20 * time.Second, pausedAt: 3 * time.Second, due: 27 * time.Second, current: 3 * time.Second},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+			logStart := start.Add(-24 * time.Hour)
+			p := newPacer(tc.speed, tc.maxGap)
+
+			for i, step := range tc.steps {
+				now := start.Add(step.now)
+				var due time.Time
+				switch step.action {
+				case "advance":
+					due = p.advance(logStart.Add(step.log), now)
+				case "speed":
+					p.setSpeed(step.speed, now)
+					due = p.due()
+				case "resume":
+					p.resume(step.pausedAt, now)
+					due = p.due()
+				}
+
+				if got := due.Sub(start); got != step.due {
+					t.Errorf("Step %d: expected due at %v, got %v", i, step.due, got)
+				}
+				if got := p.current(now); got != step.current {
+					t.Errorf("Step %d: expected position %v, got %v", i, step.current, got)
+				}
+			}
+		})
+	}
+}
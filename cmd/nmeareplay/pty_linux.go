@@ -0,0 +1,100 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyWriter writes to the master side of a pseudo-terminal without blocking.
+type ptyWriter struct {
+	master int
+	// The replay keeps the terminal open so that its settings are kept and
+	// readers can come and go
+	slave  int
+	link   string
+	linked bool
+}
+
+// newPTYOutput creates a pseudo-terminal for software that only reads serial
+// ports and links its device to link, eg. /tmp/nmea0. The terminal is in raw
+// mode so that the sentences are passed as is.
+func newPTYOutput(link string) (*writerOutput, error) {
+	master, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening /dev/ptmx: %w", err)
+	}
+	w := &ptyWriter{master: master, slave: -1, link: link}
+	if err := w.open(); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return &writerOutput{name: link, writer: w}, nil
+}
+
+func (w *ptyWriter) open() error {
+	if err := unix.IoctlSetPointerInt(w.master, unix.TIOCSPTLCK, 0); err != nil {
+		return fmt.Errorf("error unlocking pseudo-terminal: %w", err)
+	}
+	n, err := unix.IoctlGetInt(w.master, unix.TIOCGPTN)
+	if err != nil {
+		return fmt.Errorf("error getting pseudo-terminal number: %w", err)
+	}
+	device := fmt.Sprintf("/dev/pts/%d", n)
+
+	w.slave, err = unix.Open(device, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", device, err)
+	}
+	termios, err := unix.IoctlGetTermios(w.slave, unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("error getting terminal attributes: %w", err)
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(w.slave, unix.TCSETS, termios); err != nil {
+		return fmt.Errorf("error setting terminal attributes: %w", err)
+	}
+
+	// Replace the link left behind by a previous replay
+	if info, err := os.Lstat(w.link); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(w.link)
+	}
+	if err := os.Symlink(device, w.link); err != nil {
+		return err
+	}
+	w.linked = true
+	log.Printf("Writing to pseudo-terminal %s at %s", device, w.link)
+	return nil
+}
+
+// Write drops the data when the terminal buffer is full, eg. when nobody is
+// reading it.
+func (w *ptyWriter) Write(p []byte) (int, error) {
+	n, err := unix.Write(w.master, p)
+	if errors.Is(err, unix.EAGAIN) {
+		return len(p), nil
+	}
+	return n, err
+}
+
+func (w *ptyWriter) Close() error {
+	if w.slave >= 0 {
+		unix.Close(w.slave)
+	}
+	if w.linked {
+		os.Remove(w.link)
+	}
+	return unix.Close(w.master)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func newPTYOutput(link string) (*writerOutput, error) {
+	return nil, errors.New("pseudo-terminal output is only supported on Linux")
+}
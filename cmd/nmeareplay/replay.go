@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	commandPause  = "pause"
+	commandResume = "resume"
+	commandSeek   = "seek"
+	commandSpeed  = "speed"
+)
+
+// command changes the replay while it's running, see the control API.
+type command struct {
+	action string
+	time   time.Time
+	speed  float64
+	// Receives the result once the command has been carried out
+	result chan error
+}
+
+type delayedSentence struct {
+	due      time.Time
+	time     time.Time
+	sentence string
+}
+
+// replay broadcasts the log entries between startTime and endTime to the
+// clients, paced by the delays between the sentences when they were logged.
+// It runs in a single goroutine and takes commands from the control API
+// between the entries and while waiting for the next one.
+type replay struct {
+	outputs []output
+	// The TCP output if enabled, for the client count
+	tcp       *tcpOutput
+	reader    *nmealogger.LogReader
+	files     []string
+	startTime time.Time
+	endTime   time.Time
+	pacer     *pacer
+	// Wait for commands at the end instead of finishing, so that the replay
+	// can be sought back
+	interactive bool
+	// Make the sentences look live by shifting their times to the time
+	// they are sent
+	rewriteTimes bool
+	rules        *rules
+	commands     chan command
+
+	// The entry waiting to be sent and how long to delay it
+	next      *nmealogger.LogEntry
+	nextDelay time.Duration
+	// Sentences delayed by fault injection, in the order they are due
+	delayed []delayedSentence
+	// A command that arrived while waiting for slow clients, carried out
+	// once the sentence has been sent
+	pending *command
+	// Replay position when paused, to continue from
+	pausedAt time.Duration
+
+	mu        sync.Mutex
+	paused    bool
+	finished  bool
+	logTime   time.Time
+	sentences int
+	speed     float64
+	// Position of the reader in the files
+	fileIndex int
+	offset    int64
+}
+
+func newReplay(files []string, startTime, endTime time.Time, pacer *pacer) *replay {
+	return &replay{
+		reader:    nmealogger.NewLogReader(files),
+		files:     files,
+		startTime: startTime,
+		endTime:   endTime,
+		pacer:     pacer,
+		commands:  make(chan command),
+		speed:     pacer.speed,
+	}
+}
+
+// run replays the log until the end, or until there's an error reading it.
+// In interactive mode it only returns on error.
+func (r *replay) run() error {
+	defer r.reader.Close()
+
+	if !r.startTime.IsZero() {
+		if err := r.reader.Seek(r.startTime); err != nil {
+			return err
+		}
+	}
+
+	lastReported := time.Now()
+	for {
+		if r.pending != nil {
+			cmd := *r.pending
+			r.pending = nil
+			r.execute(cmd)
+			continue
+		}
+		// Carry out the commands even when replaying as fast as possible
+		select {
+		case cmd := <-r.commands:
+			r.execute(cmd)
+			continue
+		default:
+		}
+
+		r.mu.Lock()
+		waiting := r.paused || r.finished
+		finished := r.finished
+		r.mu.Unlock()
+		if finished && !r.interactive {
+			return nil
+		}
+		if waiting {
+			r.execute(<-r.commands)
+			continue
+		}
+
+		var due time.Time
+		if r.next == nil {
+			entry, err := r.reader.Next()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if err == io.EOF || (!r.endTime.IsZero() && entry.Time.After(r.endTime)) {
+				r.finish()
+				continue
+			}
+			if nmealogger.IsComment(entry.Sentence) {
+				continue
+			}
+			sentence, delay, ok := r.rules.apply(entry.Sentence)
+			if !ok {
+				continue
+			}
+			entry.Sentence = sentence
+			r.next = &entry
+			r.nextDelay = delay
+			due = r.pacer.advance(entry.Time, time.Now())
+		} else {
+			// Continuing after a command interrupted the wait
+			due = r.pacer.due()
+		}
+
+		if len(r.delayed) > 0 && r.delayed[0].due.Before(due) {
+			if r.waitUntil(r.delayed[0].due) {
+				r.sendDelayed(time.Now())
+			}
+			continue
+		}
+		if !r.waitUntil(due) {
+			continue
+		}
+
+		sentence, sentenceTime := r.next.Sentence, r.next.Time
+		if r.rewriteTimes {
+			// A whole second offset keeps the fractions of the times, and
+			// the sentences of the same fix keep the same time
+			offset := due.Sub(r.next.Time).Round(time.Second)
+			sentence, _ = nmealogger.ShiftGPSTime(sentence, r.next.Time, offset)
+			sentenceTime = sentenceTime.Add(offset)
+		}
+		if r.nextDelay > 0 {
+			r.delayed = append(r.delayed, delayedSentence{due.Add(r.nextDelay), sentenceTime, sentence})
+		} else {
+			r.send(sentenceTime, sentence)
+		}
+		r.mu.Lock()
+		r.logTime = r.next.Time
+		r.fileIndex, r.offset = r.reader.Position()
+		r.sentences++
+		sentences := r.sentences
+		r.mu.Unlock()
+		r.next = nil
+
+		if time.Since(lastReported) > StatsReportingInterval {
+			log.Printf("Replayed %d sentences, at %v, %d clients", sentences, r.logTime, r.numClients())
+			lastReported = time.Now()
+		}
+	}
+}
+
+func (r *replay) send(t time.Time, sentence string) {
+	for _, output := range r.outputs {
+		if output == r.tcp && r.pacer.speed == 0 {
+			r.broadcastWait(sentence)
+			continue
+		}
+		output.Send(t, sentence)
+	}
+}
+
+// broadcastWait sends the sentence to the TCP clients, waiting for the slow
+// ones instead of disconnecting them, as replaying as fast as possible would
+// outrun their buffers. A command stops the wait, the clients that are still
+// full miss the sentence, so that a stalled client doesn't hold up the
+// control API until it times out.
+func (r *replay) broadcastWait(sentence string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	commands := r.commands
+	if r.pending != nil {
+		// Already holding back a command, eg. when sending the delayed
+		// sentences, so don't wait for the slow clients
+		cancel()
+		commands = nil
+	}
+	done := make(chan struct{})
+	go func() {
+		r.tcp.server.BroadcastWait(ctx, sentence)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case cmd := <-commands:
+		cancel()
+		<-done
+		r.pending = &cmd
+	}
+}
+
+// sendDelayed sends the delayed sentences that are due by now.
+func (r *replay) sendDelayed(now time.Time) {
+	for len(r.delayed) > 0 && !r.delayed[0].due.After(now) {
+		r.send(r.delayed[0].time, r.delayed[0].sentence)
+		r.delayed = r.delayed[1:]
+	}
+}
+
+// numClients returns the number of TCP clients.
+func (r *replay) numClients() int {
+	if r.tcp == nil {
+		return 0
+	}
+	return r.tcp.server.NumClients()
+}
+
+// waitUntil waits for the entry to be due and returns true, or returns false
+// if a command was carried out in the meantime.
+func (r *replay) waitUntil(due time.Time) bool {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case cmd := <-r.commands:
+		r.execute(cmd)
+		return false
+	}
+}
+
+func (r *replay) finish() {
+	// Don't leave the delayed sentences behind
+	for _, delayed := range r.delayed {
+		r.send(delayed.time, delayed.sentence)
+	}
+	r.delayed = nil
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finished = true
+	if r.interactive {
+		log.Printf("Replay reached the end, %d sentences replayed. Waiting for commands.", r.sentences)
+	} else {
+		log.Printf("Replay finished, %d sentences replayed.", r.sentences)
+	}
+}
+
+func (r *replay) execute(cmd command) {
+	var err error
+	if cmd.action == commandSeek {
+		err = r.reader.Seek(cmd.time)
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	switch cmd.action {
+	case commandPause:
+		if !r.paused {
+			r.paused = true
+			r.pausedAt = r.pacer.current(now)
+		}
+	case commandResume:
+		if r.paused {
+			r.paused = false
+			r.pacer.resume(r.pausedAt, now)
+		}
+	case commandSpeed:
+		if r.paused {
+			// The position stays where it was paused
+			r.pacer.speed = cmd.speed
+		} else {
+			r.pacer.setSpeed(cmd.speed, now)
+		}
+		r.speed = cmd.speed
+	case commandSeek:
+		if err == nil {
+			r.next = nil
+			r.finished = false
+			r.logTime = cmd.time
+			r.fileIndex, r.offset = r.reader.Position()
+			r.pacer.reset()
+		}
+	}
+	r.mu.Unlock()
+
+	if cmd.action != commandSeek || err == nil {
+		log.Printf("Replay %s", describeCommand(cmd))
+	}
+	cmd.result <- err
+}
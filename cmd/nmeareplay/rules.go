@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// rules filter and rename the replayed sentences and inject faults into them,
+// eg. to test how display software copes with a GPS failure.
+type rules struct {
+	keep    []string
+	drop    []string
+	renames []nmealogger.SentenceRename
+
+	dropRate    float64
+	corruptRate float64
+	delayRate   float64
+	delay       time.Duration
+}
+
+func newRules(c *nmealogger.NMEAReplayConfig) *rules {
+	// The renames have been validated already
+	renames, _ := nmealogger.ParseSentenceRenames(c.Rename)
+	return &rules{
+		keep:        nmealogger.ParseSentenceFilter(c.Keep),
+		drop:        nmealogger.ParseSentenceFilter(c.Drop),
+		renames:     renames,
+		dropRate:    c.DropRate,
+		corruptRate: c.CorruptRate,
+		delayRate:   c.DelayRate,
+		delay:       c.Delay.Duration,
+	}
+}
+
+// apply returns the sentence to send and how long to delay it, or false if
+// the sentence is filtered out or dropped. The filters match the sentences
+// before they are renamed.
+func (r *rules) apply(sentence string) (string, time.Duration, bool) {
+	if len(r.keep) > 0 && !nmealogger.MatchSentence(r.keep, sentence) {
+		return "", 0, false
+	}
+	if nmealogger.MatchSentence(r.drop, sentence) {
+		return "", 0, false
+	}
+	sentence = nmealogger.RenameSentence(r.renames, sentence)
+
+	if chance(r.dropRate) {
+		return "", 0, false
+	}
+	if chance(r.corruptRate) {
+		sentence = corruptChecksum(sentence)
+	}
+	var delay time.Duration
+	if chance(r.delayRate) {
+		delay = r.delay
+	}
+	return sentence, delay, true
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// corruptChecksum replaces the checksum with a wrong one, or adds one to a
+// sentence without a checksum.
+func corruptChecksum(sentence string) string {
+	data, _, _ := strings.Cut(sentence, "*")
+	checksum := nmealogger.CalculateChecksum(strings.TrimLeft(data, "$!"))
+	// Changing both digits keeps the checksum valid hex, but wrong
+	wrong := []byte(checksum)
+	for i, digit := range wrong {
+		if digit == '0' {
+			wrong[i] = '1'
+		} else {
+			wrong[i] = '0'
+		}
+	}
+	return data + "*" + string(wrong)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	// Deltas buffered per SignalK client before a slow client is disconnected
+	SignalKBufferSize   = 256
+	SignalKWriteTimeout = 10 * time.Second
+	// The SignalK specification version that is served
+	SignalKVersion = "1.7.0"
+
+	signalKTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// signalKOutput serves the replay as a SignalK delta stream on
+// /signalk/v1/stream, for testing signalk-logger and SignalK apps against
+// recorded sessions. The sentences are converted with SentenceToSignalK.
+type signalKOutput struct {
+	// The context of the replayed vessel, eg. vessels.urn:mrn:signalk:uuid:...
+	self     string
+	server   *http.Server
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*signalKClient]bool
+}
+
+type signalKClient struct {
+	conn     *websocket.Conn
+	outgoing chan []byte
+
+	mu            sync.Mutex
+	subscriptions []signalKSubscription
+	// When each path was last sent, for subscriptions with a period
+	lastSent map[string]time.Time
+	closed   bool
+}
+
+// signalKSubscription is a path pattern with * wildcards, eg. navigation.*,
+// and the minimum interval between the values of a path.
+type signalKSubscription struct {
+	pattern string
+	period  time.Duration
+}
+
+// The messages from the clients, only the fields that are used
+type signalKRequest struct {
+	Context   string `json:"context"`
+	Subscribe []struct {
+		Path   string `json:"path"`
+		Period int    `json:"period"`
+	} `json:"subscribe"`
+	Unsubscribe []struct {
+		Path string `json:"path"`
+	} `json:"unsubscribe"`
+}
+
+type signalKDelta struct {
+	Context string          `json:"context"`
+	Updates []signalKUpdate `json:"updates"`
+}
+
+type signalKUpdate struct {
+	Source    string             `json:"$source"`
+	Timestamp string             `json:"timestamp"`
+	Values    []signalKPathValue `json:"values"`
+}
+
+type signalKPathValue struct {
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+func newSignalKOutput(addr string) (*signalKOutput, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return nil, err
+	}
+	// Version 4, variant 1
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	o := &signalKOutput{
+		self: fmt.Sprintf("vessels.urn:mrn:signalk:uuid:%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]),
+		upgrader: websocket.Upgrader{
+			// Allow web apps served from anywhere
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		clients: make(map[*signalKClient]bool),
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /signalk", o.serveDiscovery)
+	mux.HandleFunc("GET /signalk/v1/stream", o.serveStream)
+	o.server = &http.Server{Handler: mux}
+
+	log.Printf("Serving SignalK on %s", listener.Addr())
+	go func() {
+		if err := o.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error serving SignalK: %v", err)
+		}
+	}()
+
+	return o, nil
+}
+
+// serveDiscovery responds with the endpoints of the server.
+func (o *signalKOutput) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	discovery := map[string]any{
+		"endpoints": map[string]any{
+			"v1": map[string]any{
+				"version":    SignalKVersion,
+				"signalk-ws": "ws://" + r.Host + "/signalk/v1/stream",
+			},
+		},
+		"server": map[string]any{
+			"id":      "nmeareplay",
+			"version": nmealogger.Version,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(discovery); err != nil {
+		log.Printf("Error writing discovery response: %v", err)
+	}
+}
+
+// serveStream sends the hello message and then the deltas the client has
+// subscribed to. With ?subscribe=none the client starts without
+// subscriptions, otherwise it gets everything.
+func (o *signalKOutput) serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := o.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading SignalK connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	hello := map[string]any{
+		"name":      "nmeareplay",
+		"version":   nmealogger.Version,
+		"self":      o.self,
+		"roles":     []string{"master", "main"},
+		"timestamp": time.Now().UTC().Format(signalKTimeFormat),
+	}
+	conn.SetWriteDeadline(time.Now().Add(SignalKWriteTimeout))
+	if err := conn.WriteJSON(hello); err != nil {
+		log.Printf("Error sending SignalK hello to %s: %v", r.RemoteAddr, err)
+		conn.Close()
+		return
+	}
+
+	client := &signalKClient{
+		conn:     conn,
+		outgoing: make(chan []byte, SignalKBufferSize),
+		lastSent: make(map[string]time.Time),
+	}
+	if r.URL.Query().Get("subscribe") != "none" {
+		client.subscriptions = []signalKSubscription{{pattern: "*"}}
+	}
+
+	o.mu.Lock()
+	o.clients[client] = true
+	o.mu.Unlock()
+	log.Printf("SignalK client connected from %s", r.RemoteAddr)
+
+	go o.writeToClient(client)
+	o.readFromClient(client)
+
+	o.removeClient(client)
+	log.Printf("SignalK client %s disconnected", r.RemoteAddr)
+}
+
+// readFromClient handles the subscribe and unsubscribe requests until the
+// connection is closed.
+func (o *signalKOutput) readFromClient(client *signalKClient) {
+	for {
+		_, message, err := client.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request signalKRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			continue
+		}
+		// There's only the one vessel
+		if request.Context != "" && request.Context != "vessels.self" && request.Context != "*" &&
+			request.Context != "vessels.*" && request.Context != o.self {
+			continue
+		}
+
+		client.mu.Lock()
+		for _, unsubscribe := range request.Unsubscribe {
+			if unsubscribe.Path == "*" {
+				client.subscriptions = nil
+				continue
+			}
+			var kept []signalKSubscription
+			for _, subscription := range client.subscriptions {
+				if subscription.pattern != unsubscribe.Path {
+					kept = append(kept, subscription)
+				}
+			}
+			client.subscriptions = kept
+		}
+		for _, subscribe := range request.Subscribe {
+			client.subscriptions = append(client.subscriptions, signalKSubscription{
+				pattern: subscribe.Path,
+				period:  time.Duration(subscribe.Period) * time.Millisecond,
+			})
+		}
+		client.mu.Unlock()
+	}
+}
+
+func (o *signalKOutput) writeToClient(client *signalKClient) {
+	defer o.removeClient(client)
+
+	for message := range client.outgoing {
+		client.conn.SetWriteDeadline(time.Now().Add(SignalKWriteTimeout))
+		if err := client.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return
+		}
+	}
+}
+
+func (o *signalKOutput) removeClient(client *signalKClient) {
+	o.mu.Lock()
+	delete(o.clients, client)
+	o.mu.Unlock()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if !client.closed {
+		client.closed = true
+		close(client.outgoing)
+		client.conn.Close()
+	}
+}
+
+// Send converts the sentence to SignalK values and sends them to the clients
+// that have subscribed to them, timestamped with t. Clients that fall too far
+// behind are disconnected.
+func (o *signalKOutput) Send(t time.Time, sentence string) {
+	values := nmealogger.SentenceToSignalK(sentence)
+	if len(values) == 0 {
+		return
+	}
+	talker, _, _ := nmealogger.SentenceID(sentence)
+	update := signalKUpdate{
+		Source:    "nmeareplay." + talker,
+		Timestamp: t.UTC().Format(signalKTimeFormat),
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	for client := range o.clients {
+		client.mu.Lock()
+		update.Values = client.filter(values, now)
+		if len(update.Values) == 0 || client.closed {
+			client.mu.Unlock()
+			continue
+		}
+		message, err := json.Marshal(signalKDelta{Context: o.self, Updates: []signalKUpdate{update}})
+		if err != nil {
+			client.mu.Unlock()
+			log.Printf("Error marshaling SignalK delta: %v", err)
+			return
+		}
+
+		select {
+		case client.outgoing <- message:
+			client.mu.Unlock()
+		default:
+			client.mu.Unlock()
+			log.Printf("SignalK client %s is too slow, disconnecting", client.conn.RemoteAddr())
+			// The writer removes the client once the connection is closed
+			client.conn.Close()
+		}
+	}
+}
+
+// filter returns the values the client has subscribed to and that are due
+// by the subscription period. Must be called with client.mu held.
+func (c *signalKClient) filter(values []nmealogger.SignalKValue, now time.Time) []signalKPathValue {
+	var filtered []signalKPathValue
+	for _, value := range values {
+		for _, subscription := range c.subscriptions {
+			if matched, _ := path.Match(subscription.pattern, value.Path); !matched {
+				continue
+			}
+			if subscription.period > 0 && now.Sub(c.lastSent[value.Path]) < subscription.period {
+				continue
+			}
+			c.lastSent[value.Path] = now
+			filtered = append(filtered, signalKPathValue{Path: value.Path, Value: value.Value})
+			break
+		}
+	}
+	return filtered
+}
+
+func (o *signalKOutput) Close() {
+	o.server.Close()
+
+	o.mu.Lock()
+	clients := make([]*signalKClient, 0, len(o.clients))
+	for client := range o.clients {
+		clients = append(clients, client)
+	}
+	o.mu.Unlock()
+
+	for _, client := range clients {
+		o.removeClient(client)
+	}
+}
@@ -19,6 +19,20 @@ type SignalKLogWriter struct {
 	requiredFields       []string
 	missingFieldsTimeout time.Duration
 	lastWrite            time.Time
+	currentFile          string
+	bytesWritten         int
+}
+
+// countingWriter counts the bytes that csv.Writer flushes to the file.
+type countingWriter struct {
+	w     io.Writer
+	count *int
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.count += n
+	return n, err
 }
 
 func NewSignalKLogWriter(
@@ -38,7 +52,9 @@ func NewSignalKLogWriter(
 	}
 }
 
-func (lw *SignalKLogWriter) AddRecord(record *Record) error {
+// AddRecord writes the record to the log if it has all the required fields or
+// if nothing has been written for a while. Returns the number of bytes written.
+func (lw *SignalKLogWriter) AddRecord(record *Record) (int, error) {
 	forceWrite := false
 	if !lw.lastWrite.IsZero() && lw.lastWrite.Before(time.Now().Add(-lw.missingFieldsTimeout)) {
 		forceWrite = true
@@ -54,20 +70,28 @@ func (lw *SignalKLogWriter) AddRecord(record *Record) error {
 			values = append(values, strVal)
 		}
 
+		bytesBefore := lw.bytesWritten
 		csvWriter, err := lw.getWriter()
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		record.Clear()
 		lw.lastWrite = time.Now()
 
-		err = csvWriter.Write(values)
+		if err := csvWriter.Write(values); err != nil {
+			return 0, err
+		}
 		csvWriter.Flush()
-		return err
+		return lw.bytesWritten - bytesBefore, csvWriter.Error()
 	}
 
-	return nil
+	return 0, nil
+}
+
+// CurrentFile returns the path of the file currently being written to.
+func (lw *SignalKLogWriter) CurrentFile() string {
+	return lw.currentFile
 }
 
 func (lw *SignalKLogWriter) Close() {
@@ -97,7 +121,8 @@ func (lw *SignalKLogWriter) getWriter() (*csv.Writer, error) {
 			return nil, fmt.Errorf("error opening %s for writing: %w", pathName, err)
 		}
 
-		lw.csvWriter = csv.NewWriter(lw.writer)
+		lw.currentFile = pathName
+		lw.csvWriter = csv.NewWriter(countingWriter{w: lw.writer, count: &lw.bytesWritten})
 		// TODO: Write the header with the record so that all writing is in one place
 		// also that we don't have just files with headers
 		header := append([]string{"time"}, lw.requiredFields...)
@@ -1,60 +1,149 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+	"github.com/mpihlak/go-nmealogger/sqlitesink"
 )
 
 const (
-	// At most this amount of data in the logfile. Keep reasonably small to improve data
-	// freshness (uploader only considers rotated files)
-	FileRotationInterval = 5 * time.Minute
-	// Ask SignalK to report measurements at this interval
-	SignalKReportingIntervalMs = 1 * time.Second
-	// If no data has been received within this time write the record anyway
-	SignalKMissingDataTimeout = 2 * time.Second
-	// Drop data that is older than the stale threshold
-	SkipStaleDataThreshold = 15 * time.Second
+	// Reconnect delays grow exponentially from min to max while SignalK is unavailable
+	ReconnectMinDelay = 1 * time.Second
+	ReconnectMaxDelay = 60 * time.Second
+	// Start over from the minimum delay if the connection stayed up at least this long
+	ReconnectResetInterval = 60 * time.Second
+	// Send websocket pings this often to check that the server is still alive
+	PingInterval = 10 * time.Second
 )
 
 func main() {
-	logDirectory := flag.String("logDir", "data", "Directory where log files will be stored")
-	signalK := flag.String("signalk-addr", "localhost:3000", "SignalK hostport")
-	flag.Parse()
+	cfg := nmealogger.DefaultConfig()
+	c := &cfg.SignalKLogger
+	flag.StringVar(&c.LogDir, "logDir", c.LogDir, "Directory where log files will be stored")
+	flag.StringVar(&c.SignalKAddr, "signalk-addr", c.SignalKAddr, "SignalK hostport")
+	flag.StringVar(&c.StatusAddr, "statusAddr", c.StatusAddr, "Serve /metrics and /status on this hostport, disabled if empty")
+	flag.DurationVar(&c.ReadTimeout.Duration, "readTimeout", c.ReadTimeout.Duration, "Reconnect if nothing is received from SignalK within this time")
+	flag.DurationVar(&c.FileRotationInterval.Duration, "fileRotationInterval", c.FileRotationInterval.Duration, "Start a new log file after this interval")
+	flag.DurationVar(&c.ReportingInterval.Duration, "reportingInterval", c.ReportingInterval.Duration, "Ask SignalK to report measurements at this interval")
+	flag.DurationVar(&c.MissingDataTimeout.Duration, "missingDataTimeout", c.MissingDataTimeout.Duration, "Write the record anyway if not all fields are received within this time")
+	flag.DurationVar(&c.StaleDataThreshold.Duration, "staleDataThreshold", c.StaleDataThreshold.Duration, "Drop data that is older than this")
+	flag.StringVar(&c.SQLiteDB, "sqliteDB", c.SQLiteDB, "Also store the values in this SQLite database, disabled if empty")
+	flag.DurationVar(&c.SQLiteRetention.Duration, "sqliteRetention", c.SQLiteRetention.Duration, "Delete data older than this from the database, 0 keeps everything")
+	nmealogger.ParseConfig(cfg, c.Validate)
 
-	log.Printf("Starting SignalK logger: log directory = %s, signalK = %s", *logDirectory, *signalK)
+	log.Printf("Starting SignalK logger: log directory = %s, signalK = %s", c.LogDir, c.SignalKAddr)
 
-	if err := os.MkdirAll(*logDirectory, os.ModePerm); err != nil {
+	if err := os.MkdirAll(c.LogDir, os.ModePerm); err != nil {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
 	u := url.URL{
 		Scheme:   "ws",
-		Host:     *signalK,
+		Host:     c.SignalKAddr,
 		Path:     "/signalk/v1/stream",
 		RawQuery: "subscribe=none",
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	status := NewStatus(u.String())
+	if c.StatusAddr != "" {
+		status.Serve(c.StatusAddr)
+	}
+
+	watchdog := nmealogger.NewWatchdog()
+	go watchdog.Run(ctx)
+
+	var sink *sqlitesink.Sink
+	if c.SQLiteDB != "" {
+		var err error
+		sink, err = sqlitesink.Open(c.SQLiteDB, c.SQLiteRetention.Duration)
+		if err != nil {
+			log.Fatalf("Error opening database: %v", err)
+		}
+		log.Printf("Storing values in %s", c.SQLiteDB)
+		defer sink.Close()
+	}
+
 	log.Printf("Connecting to %s", u.String())
 
-	for {
-		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	// Not waiting for the connection, the watchdog takes care of a server
+	// that never sends anything
+	if err := nmealogger.SdNotify("READY=1"); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	}
+
+	backoff := nmealogger.NewBackoff(ReconnectMinDelay, ReconnectMaxDelay)
+	for ctx.Err() == nil {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
 		if err != nil {
+			delay := backoff.Next()
 			log.Printf("Error connecting to SignalK: %v", err)
-			log.Printf("Retrying ...")
-			time.Sleep(1 * time.Second)
+			log.Printf("Retrying in %v ...", delay.Round(time.Millisecond))
+			sleep(ctx, delay)
 			continue
 		}
 
 		log.Println("Connected to SignalK, start processing")
-		processMessages(conn, *logDirectory)
+
+		connectedAt := time.Now()
+		status.SetConnected(true)
+		processMessages(ctx, conn, c, status, watchdog, sink)
+		status.SetConnected(false)
+
+		if time.Since(connectedAt) > ReconnectResetInterval {
+			backoff.Reset()
+		}
+		if ctx.Err() == nil {
+			delay := backoff.Next()
+			log.Printf("Reconnecting in %v ...", delay.Round(time.Millisecond))
+			sleep(ctx, delay)
+		}
+	}
+
+	log.Printf("Shutting down")
+	nmealogger.SdNotify("STOPPING=1")
+}
+
+// sleep waits for the duration or until the context is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// keepAlive pings the server until done is closed. The pongs extend the read
+// deadline, see processMessages, so that a connection that is alive but quiet
+// isn't dropped, while a dead one times out in ReadMessage.
+func keepAlive(c *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(PingInterval)
+			if err := c.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Printf("Error sending ping to SignalK: %v", err)
+				return
+			}
+		}
 	}
 }
 
@@ -87,9 +176,47 @@ type SignalKMessage struct {
 	} `json:"updates"`
 }
 
-func processMessages(c *websocket.Conn, logDirectory string) error {
+func processMessages(
+	ctx context.Context,
+	c *websocket.Conn,
+	cfg *nmealogger.SignalKLoggerConfig,
+	status *Status,
+	watchdog *nmealogger.Watchdog,
+	sink *sqlitesink.Sink,
+) error {
 	defer c.Close()
 
+	// Unblock the read on shutdown so that the log file is closed properly
+	stopRead := context.AfterFunc(ctx, func() {
+		c.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+		c.Close()
+	})
+	defer stopRead()
+
+	// Commit the values received before the connection was lost, rather than
+	// when the next ones arrive
+	if sink != nil {
+		defer func() {
+			if err := sink.Commit(); err != nil {
+				log.Printf("Error writing to database: %v", err)
+			}
+		}()
+	}
+
+	readTimeout := cfg.ReadTimeout.Duration
+	if err := c.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return err
+	}
+	// The handler runs in ReadMessage, so it's installed before the pings
+	// start rather than by keepAlive, which runs concurrently with the reads
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go keepAlive(c, done)
+
 	_, helloMsg, err := c.ReadMessage()
 	if err != nil {
 		log.Printf("Error reading Hello message from signalK: %v", err)
@@ -98,57 +225,15 @@ func processMessages(c *websocket.Conn, logDirectory string) error {
 	log.Printf("Got hello: %v\n", string(helloMsg))
 	log.Printf("Subscribing ...")
 
-	subscriptions := Subscriptions{
-		Context: "vessels.self",
-		Subscribe: []Topic{
-			{Path: "environment.depth.belowTransducer", Period: 1000},
-			{Path: "environment.water.temperature", Period: 1000},
-			{Path: "environment.wind.angleApparent", Period: 1000},
-			{Path: "environment.wind.speedApparent", Period: 1000},
-			{Path: "navigation.courseOverGroundTrue", Period: 1000},
-			{Path: "navigation.datetime", Period: 1000},
-			{Path: "navigation.headingMagnetic", Period: 1000},
-			{Path: "navigation.magneticVariation", Period: 1000},
-			{Path: "navigation.rateOfTurn", Period: 1000},
-			{Path: "navigation.speedOverGround", Period: 1000},
-			{Path: "navigation.speedThroughWater", Period: 1000},
-			{Path: "navigation.attitude", Period: 1000},
-			{Path: "navigation.position", Period: 1000},
-		},
-	}
-
-	// If a measurement has multiple sources we need to choose which one to use.
-	// ignoreSources map specifies values to drop from specific sources.
-	ignoreSources := map[string]string{
-		"environment.wind.angleApparent":  "can0.15",
-		"environment.wind.speedApparent":  "can0.15",
-		"navigation.courseOverGroundTrue": "can0.85",
-		"navigation.datetime":             "can0.85",
-		"navigation.headingMagnetic":      "can0.85",
-		"navigation.magneticVariation":    "can0.85",
-		"navigation.speedOverGround":      "can0.85",
-		"navigation.position":             "can0.85",
-	}
-
-	requiredFields := []string{
-		"environment.depth.belowTransducer",
-		"environment.water.temperature",
-		"environment.wind.angleApparent",
-		"environment.wind.speedApparent",
-		"navigation.courseOverGroundTrue",
-		"navigation.headingMagnetic",
-		"navigation.magneticVariation",
-		"navigation.rateOfTurn",
-		"navigation.speedOverGround",
-		"navigation.speedThroughWater",
-		"navigation.attitude.pitch",
-		"navigation.attitude.yaw",
-		"navigation.attitude.roll",
-		"navigation.position.longitude",
-		"navigation.position.latitude",
-	}
-
-	logWriter := NewSignalKLogWriter(logDirectory, requiredFields, SignalKMissingDataTimeout, FileRotationInterval)
+	subscriptions := Subscriptions{Context: "vessels.self"}
+	for _, path := range cfg.Subscriptions {
+		subscriptions.Subscribe = append(subscriptions.Subscribe, Topic{
+			Path:   path,
+			Period: int(cfg.ReportingInterval.Milliseconds()),
+		})
+	}
+
+	logWriter := NewSignalKLogWriter(cfg.LogDir, cfg.RequiredFields, cfg.MissingDataTimeout.Duration, cfg.FileRotationInterval.Duration)
 	defer logWriter.Close()
 
 	buf, err := json.Marshal(subscriptions)
@@ -166,28 +251,44 @@ func processMessages(c *websocket.Conn, logDirectory string) error {
 	for {
 		_, buf, err := c.ReadMessage()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			log.Printf("Error reading from SignalK: %v", err)
 			return err
 		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := c.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return err
+		}
+		status.MessageReceived()
+		watchdog.Activity()
 
 		var message SignalKMessage
 		err = json.Unmarshal(buf, &message)
 		if err != nil {
 			log.Printf("Error unmarshalling SignalK message [%v]: %v", string(buf), err)
+			status.ValueRejected("unmarshal")
 			continue
 		}
 
 		for _, update := range message.Updates {
 			for _, value := range update.Values {
-				if ignoreSources[value.Path] == update.SourceRef {
+				if cfg.IgnoreSources[value.Path] == update.SourceRef {
+					status.ValueRejected("ignored_source")
 					continue
 				}
-				if time.Now().Sub(update.Timestamp) > SkipStaleDataThreshold {
+				if time.Now().Sub(update.Timestamp) > cfg.StaleDataThreshold.Duration {
 					log.Printf("Ignoring stale field: %s %v", value.Path, update.Timestamp)
+					status.ValueRejected("stale")
 					continue
 				}
 				if val, ok := value.Value.(float64); ok {
 					record.AddValue(update.Timestamp, value.Path, val)
+					status.ValueReceived(value.Path)
+					storeValue(sink, update.Timestamp, value.Path, update.SourceRef, val)
 				} else if val, ok := value.Value.(string); ok {
 					// log.Printf("Ignoring string value: %v=%v", value.Path, val)
 					// Ignore string values
@@ -196,16 +297,38 @@ func processMessages(c *websocket.Conn, logDirectory string) error {
 						if val, ok := v.(float64); ok {
 							recordKey := fmt.Sprintf("%v.%v", value.Path, k)
 							record.AddValue(update.Timestamp, recordKey, val)
+							status.ValueReceived(recordKey)
+							storeValue(sink, update.Timestamp, recordKey, update.SourceRef, val)
 						} else {
 							log.Printf("Ignoring unknown map value: %v.%v=%v", value.Path, k, val)
+							status.ValueRejected("unknown_type")
 						}
 					}
 				} else {
 					log.Printf("Ignoring unknown value: %v=%v", value.Path, val)
+					status.ValueRejected("unknown_type")
 				}
 			}
 		}
 
-		logWriter.AddRecord(record)
+		bytes, err := logWriter.AddRecord(record)
+		if err != nil {
+			log.Printf("Error writing log entry: %v", err)
+			return err
+		}
+		if bytes > 0 {
+			status.RecordLogged(logWriter.CurrentFile(), bytes)
+		}
+	}
+}
+
+// storeValue writes the value to the database if one is configured. Database
+// errors are only logged so that they don't stop the text logs.
+func storeValue(sink *sqlitesink.Sink, t time.Time, path string, source string, value float64) {
+	if sink == nil {
+		return
+	}
+	if err := sink.WriteValue(t, path, source, value); err != nil {
+		log.Printf("Error writing to database: %v", err)
 	}
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	valuesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalk_logger_values_total",
+		Help: "Number of SignalK values added to records, by path.",
+	}, []string{"path"})
+	valuesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalk_logger_rejected_values_total",
+		Help: "Number of SignalK values or messages dropped, by reason.",
+	}, []string{"reason"})
+	recordsLogged = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signalk_logger_records_total",
+		Help: "Number of records written to log files.",
+	})
+	bytesWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signalk_logger_bytes_written_total",
+		Help: "Number of bytes written to log files.",
+	})
+	currentFile = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "signalk_logger_current_file_info",
+		Help: "The log file currently being written to.",
+	}, []string{"file"})
+	connected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signalk_logger_connected",
+		Help: "Whether the logger is connected to the SignalK server.",
+	})
+)
+
+// Status keeps track of the logger state for the /status page and the
+// metrics that are not simple counters.
+type Status struct {
+	mu              sync.Mutex
+	startTime       time.Time
+	server          string
+	connected       bool
+	currentFile     string
+	lastMessageTime time.Time
+	valuesReceived  int
+	valuesRejected  int
+	recordsLogged   int
+	bytesWritten    int
+}
+
+func NewStatus(server string) *Status {
+	s := &Status{
+		startTime: time.Now(),
+		server:    server,
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "signalk_logger_last_message_age_seconds",
+		Help: "Seconds since the last message was received from SignalK.",
+	}, func() float64 {
+		return s.lastMessageAge().Seconds()
+	})
+
+	return s
+}
+
+func (s *Status) SetConnected(isConnected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connected = isConnected
+	if isConnected {
+		connected.Set(1)
+	} else {
+		connected.Set(0)
+	}
+}
+
+func (s *Status) MessageReceived() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMessageTime = time.Now()
+}
+
+func (s *Status) ValueReceived(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	valuesReceived.WithLabelValues(path).Inc()
+	s.valuesReceived++
+}
+
+func (s *Status) ValueRejected(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	valuesRejected.WithLabelValues(reason).Inc()
+	s.valuesRejected++
+}
+
+func (s *Status) RecordLogged(fileName string, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordsLogged.Inc()
+	bytesWritten.Add(float64(bytes))
+
+	if fileName != s.currentFile {
+		currentFile.Reset()
+		currentFile.WithLabelValues(fileName).Set(1)
+		s.currentFile = fileName
+	}
+
+	s.recordsLogged++
+	s.bytesWritten += bytes
+}
+
+func (s *Status) lastMessageAge() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastMessageTime.IsZero() {
+		return time.Since(s.startTime)
+	}
+	return time.Since(s.lastMessageTime)
+}
+
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	age := s.lastMessageAge()
+
+	s.mu.Lock()
+	status := struct {
+		StartTime       time.Time  `json:"startTime"`
+		Server          string     `json:"server"`
+		Connected       bool       `json:"connected"`
+		CurrentFile     string     `json:"currentFile"`
+		LastMessageTime *time.Time `json:"lastMessageTime"`
+		LastMessageAge  float64    `json:"lastMessageAgeSeconds"`
+		ValuesReceived  int        `json:"valuesReceived"`
+		ValuesRejected  int        `json:"valuesRejected"`
+		RecordsLogged   int        `json:"recordsLogged"`
+		BytesWritten    int        `json:"bytesWritten"`
+	}{
+		StartTime:      s.startTime,
+		Server:         s.server,
+		Connected:      s.connected,
+		CurrentFile:    s.currentFile,
+		LastMessageAge: age.Seconds(),
+		ValuesReceived: s.valuesReceived,
+		ValuesRejected: s.valuesRejected,
+		RecordsLogged:  s.recordsLogged,
+		BytesWritten:   s.bytesWritten,
+	}
+	if !s.lastMessageTime.IsZero() {
+		lastMessageTime := s.lastMessageTime
+		status.LastMessageTime = &lastMessageTime
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("Error writing status response: %v", err)
+	}
+}
+
+// Serve starts the HTTP server for /metrics and /status in the background.
+func (s *Status) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/status", s)
+
+	log.Printf("Serving metrics and status on %s", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("Error serving metrics and status: %v", err)
+		}
+	}()
+}
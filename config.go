@@ -0,0 +1,590 @@
+package nmealogger
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// DefaultConfigFile is where the installed package keeps its configuration.
+	DefaultConfigFile = "/opt/nmealogger/etc/nmealogger.toml"
+	// ReplayTimeFormat is the format of the replay start time, UTC time zone.
+	ReplayTimeFormat = "2006-01-02T15:04:05"
+)
+
+// Config holds the settings for all the binaries, each in its own section of
+// the config file. The keys are named after the corresponding flags.
+type Config struct {
+	NMEALogger    NMEALoggerConfig    `toml:"nmealogger"`
+	SignalKLogger SignalKLoggerConfig `toml:"signalk-logger"`
+	Storage       StorageConfig       `toml:"storage"`
+	Drive         DriveConfig         `toml:"drive"`
+	LogUpload     LogUploadConfig     `toml:"logupload"`
+	LogDownload   LogDownloadConfig   `toml:"logdownload"`
+	NMEAReplay    NMEAReplayConfig    `toml:"nmeareplay"`
+	LogTimeFix    LogTimeFixConfig    `toml:"logtimefix"`
+}
+
+type NMEALoggerConfig struct {
+	LogDir string `toml:"logDir"`
+	Kplex  string `toml:"kplex"`
+	// Read from gpsd at this hostport instead of kplex if set
+	Gpsd                 string   `toml:"gpsd"`
+	StatusAddr           string   `toml:"statusAddr"`
+	ServeAddr            string   `toml:"serveAddr"`
+	ServeFilter          string   `toml:"serveFilter"`
+	ServeBuffer          int      `toml:"serveBuffer"`
+	ReadTimeout          Duration `toml:"readTimeout"`
+	FileRotationInterval Duration `toml:"fileRotationInterval"`
+	// Timestamp the log entries with the "system" clock or the "gps" disciplined clock
+	TimeSource string `toml:"timeSource"`
+	// Write the "raw" .log files, "jsonl" JSON Lines files with decoded fields or "both"
+	LogFormat string `toml:"logFormat"`
+	// Also store the decoded sentences in this SQLite database if set
+	SQLiteDB string `toml:"sqliteDB"`
+	// Delete data older than this from the database, 0 keeps everything
+	SQLiteRetention Duration `toml:"sqliteRetention"`
+}
+
+type SignalKLoggerConfig struct {
+	LogDir      string   `toml:"logDir"`
+	SignalKAddr string   `toml:"signalk-addr"`
+	StatusAddr  string   `toml:"statusAddr"`
+	ReadTimeout Duration `toml:"readTimeout"`
+	// At most this amount of data in the logfile. Keep reasonably small to improve data
+	// freshness (uploader only considers rotated files)
+	FileRotationInterval Duration `toml:"fileRotationInterval"`
+	// Ask SignalK to report the subscribed paths at this interval
+	ReportingInterval Duration `toml:"reportingInterval"`
+	// If no data has been received within this time write the record anyway
+	MissingDataTimeout Duration `toml:"missingDataTimeout"`
+	// Drop data that is older than the stale threshold
+	StaleDataThreshold Duration `toml:"staleDataThreshold"`
+	Subscriptions      []string `toml:"subscriptions"`
+	// If a measurement has multiple sources we need to choose which one to use.
+	// IgnoreSources specifies values to drop from specific sources.
+	IgnoreSources  map[string]string `toml:"ignoreSources"`
+	RequiredFields []string          `toml:"requiredFields"`
+	// Also store the values in this SQLite database if set
+	SQLiteDB string `toml:"sqliteDB"`
+	// Delete data older than this from the database, 0 keeps everything
+	SQLiteRetention Duration `toml:"sqliteRetention"`
+}
+
+// StorageConfig selects where logupload and logdownload keep the logs. The
+// Drive settings are in DriveConfig.
+type StorageConfig struct {
+	// "drive", "s3", "webdav", "sftp" or "dir"
+	Backend string       `toml:"backend"`
+	S3      S3Config     `toml:"s3"`
+	WebDAV  WebDAVConfig `toml:"webdav"`
+	SFTP    SFTPConfig   `toml:"sftp"`
+	// A local or remote-mounted directory, eg. a NAS share
+	Dir string `toml:"dir"`
+}
+
+// S3Config is for AWS S3 and S3-compatible object stores such as MinIO.
+type S3Config struct {
+	// Host and optional port, eg. s3.eu-north-1.amazonaws.com
+	Endpoint string `toml:"endpoint"`
+	Region   string `toml:"region"`
+	Bucket   string `toml:"bucket"`
+	// Prepended to the file names, eg. "boat/"
+	Prefix string `toml:"prefix"`
+	// Taken from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment
+	// variables if empty
+	AccessKey string `toml:"accessKey"`
+	SecretKey string `toml:"secretKey"`
+	// Use plain HTTP, eg. for a MinIO server on the local network
+	Insecure bool `toml:"insecure"`
+}
+
+type WebDAVConfig struct {
+	// URL of the collection the logs are stored in, eg. a Nextcloud folder
+	URL      string `toml:"url"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+}
+
+type SFTPConfig struct {
+	// Host and optional port
+	Addr     string `toml:"addr"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+	// Private key file, used instead of the password if set
+	KeyFile string `toml:"keyFile"`
+	// known_hosts file for verifying the server's host key, eg.
+	// /root/.ssh/known_hosts
+	KnownHosts string `toml:"knownHosts"`
+	// Directory on the server, relative to the login directory if not absolute
+	Dir string `toml:"dir"`
+}
+
+type DriveConfig struct {
+	Credentials string `toml:"credentials"`
+	FolderID    string `toml:"folderId"`
+}
+
+type LogUploadConfig struct {
+	LogDir          string   `toml:"logDir"`
+	DontRenameFiles bool     `toml:"dontRenameFiles"`
+	FileAgeCutOff   Duration `toml:"fileAgeCutOff"`
+	// Retry uploads that fail with a transient error this many times before
+	// moving on to the next file. Later runs resume from where they stopped.
+	Retries int `toml:"retries"`
+}
+
+type LogDownloadConfig struct {
+	LogDir   string `toml:"logDir"`
+	Delete   bool   `toml:"delete"`
+	Download bool   `toml:"download"`
+	// Only list the metadata of the logs in Drive
+	List bool `toml:"list"`
+	// Only process logs that overlap with the time range, dates or times in
+	// UTC as "2024-07-15" or "2024-07-15T13:00:00"
+	After  string `toml:"after"`
+	Before string `toml:"before"`
+	// Only process logs with at least this distance sailed, in nautical miles
+	MinDistance float64 `toml:"minDistance"`
+}
+
+type NMEAReplayConfig struct {
+	// Log file, directory of log files or a glob pattern. More can be given
+	// as command line arguments.
+	InputFile string `toml:"inputFile"`
+	StartTime string `toml:"startTime"`
+	EndTime   string `toml:"endTime"`
+	// Replay speed as a multiple of real time, 0 for as fast as possible
+	Speed float64 `toml:"speed"`
+	// Gaps between sentences longer than this are shortened to it, 0 keeps them
+	MaxGap Duration `toml:"maxGap"`
+	// Don't start the replay until the first client has connected
+	WaitForClient bool `toml:"waitForClient"`
+	// Serve the HTTP control API on this hostport, disabled if empty
+	ControlAddr string `toml:"controlAddr"`
+	// Shift the times in RMC, ZDA, GGA and GLL sentences to the time of replay
+	RewriteTimes bool `toml:"rewriteTimes"`
+	// Serve the replay to TCP clients on this hostport, disabled if empty
+	ListenAddr string `toml:"listenAddr"`
+	// Send the sentences as UDP datagrams to this unicast or broadcast hostport
+	UDPAddr string `toml:"udpAddr"`
+	// Write the sentences to stdout
+	Stdout bool `toml:"stdout"`
+	// Write the sentences to a pseudo-terminal linked to this path
+	PTY string `toml:"pty"`
+	// Serve the replay as a SignalK delta stream on this hostport
+	SignalKAddr string `toml:"signalkAddr"`
+	// Replay only the sentences matching these patterns, eg. "RMC,II", and
+	// leave out the ones matching drop
+	Keep string `toml:"keep"`
+	Drop string `toml:"drop"`
+	// Rename talkers and sentence types, eg. "II=GP,VWR=MWV"
+	Rename string `toml:"rename"`
+	// Fraction of the sentences to drop, to send with a corrupted checksum
+	// and to send delay late
+	DropRate    float64  `toml:"dropRate"`
+	CorruptRate float64  `toml:"corruptRate"`
+	DelayRate   float64  `toml:"delayRate"`
+	Delay       Duration `toml:"delay"`
+}
+
+type LogTimeFixConfig struct {
+	// Write the corrected files here, empty to replace the original files
+	OutputDir string `toml:"outputDir"`
+	DryRun    bool   `toml:"dryRun"`
+}
+
+// Duration is a time.Duration that is written as "5m0s" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		NMEALogger: NMEALoggerConfig{
+			LogDir:               "data",
+			Kplex:                "127.0.0.1:10110",
+			ServeBuffer:          256,
+			ReadTimeout:          Duration{30 * time.Second},
+			FileRotationInterval: Duration{5 * time.Minute},
+			TimeSource:           "system",
+			LogFormat:            "raw",
+		},
+		SignalKLogger: SignalKLoggerConfig{
+			LogDir:               "data",
+			SignalKAddr:          "localhost:3000",
+			ReadTimeout:          Duration{30 * time.Second},
+			FileRotationInterval: Duration{5 * time.Minute},
+			ReportingInterval:    Duration{1 * time.Second},
+			MissingDataTimeout:   Duration{2 * time.Second},
+			StaleDataThreshold:   Duration{15 * time.Second},
+			Subscriptions: []string{
+				"environment.depth.belowTransducer",
+				"environment.water.temperature",
+				"environment.wind.angleApparent",
+				"environment.wind.speedApparent",
+				"navigation.courseOverGroundTrue",
+				"navigation.datetime",
+				"navigation.headingMagnetic",
+				"navigation.magneticVariation",
+				"navigation.rateOfTurn",
+				"navigation.speedOverGround",
+				"navigation.speedThroughWater",
+				"navigation.attitude",
+				"navigation.position",
+			},
+			IgnoreSources: map[string]string{
+				"environment.wind.angleApparent":  "can0.15",
+				"environment.wind.speedApparent":  "can0.15",
+				"navigation.courseOverGroundTrue": "can0.85",
+				"navigation.datetime":             "can0.85",
+				"navigation.headingMagnetic":      "can0.85",
+				"navigation.magneticVariation":    "can0.85",
+				"navigation.speedOverGround":      "can0.85",
+				"navigation.position":             "can0.85",
+			},
+			RequiredFields: []string{
+				"environment.depth.belowTransducer",
+				"environment.water.temperature",
+				"environment.wind.angleApparent",
+				"environment.wind.speedApparent",
+				"navigation.courseOverGroundTrue",
+				"navigation.headingMagnetic",
+				"navigation.magneticVariation",
+				"navigation.rateOfTurn",
+				"navigation.speedOverGround",
+				"navigation.speedThroughWater",
+				"navigation.attitude.pitch",
+				"navigation.attitude.yaw",
+				"navigation.attitude.roll",
+				"navigation.position.longitude",
+				"navigation.position.latitude",
+			},
+		},
+		Storage: StorageConfig{
+			Backend: "drive",
+		},
+		LogUpload: LogUploadConfig{
+			LogDir:        "data",
+			FileAgeCutOff: Duration{10 * time.Minute},
+			Retries:       3,
+		},
+		LogDownload: LogDownloadConfig{
+			LogDir:   "data",
+			Download: true,
+		},
+		NMEAReplay: NMEAReplayConfig{
+			Speed:      1,
+			ListenAddr: "0.0.0.0:10110",
+			Delay:      Duration{2 * time.Second},
+		},
+	}
+}
+
+// ParseConfig parses the command line flags and loads the config file given
+// with -config on top of cfg. Flags that were set on the command line take
+// precedence over the config file. The flags must be defined with cfg fields
+// as their destinations.
+//
+// With -printConfig the effective configuration is printed and the program
+// exits. Otherwise the config is checked with
+// validate and the program exits if it's not valid.
+func ParseConfig(cfg *Config, validate func() error) {
+	configFile := flag.String("config", DefaultConfigFile, "Configuration file")
+	printConfig := flag.Bool("printConfig", false, "Print the effective configuration and exit")
+	flag.Parse()
+
+	if err := cfg.Load(*configFile, flag.CommandLine); err != nil {
+		if *configFile != DefaultConfigFile || !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Error loading configuration: %v", err)
+		}
+	}
+
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("Error printing configuration: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if err := validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+}
+
+// Load reads the config file on top of the current values and then applies
+// the flags that were explicitly set in fs again.
+func (cfg *Config) Load(path string, fs *flag.FlagSet) error {
+	setFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	// Tables in the file replace the defaults instead of being merged with them
+	var fileConfig Config
+	if _, err := toml.DecodeFile(path, &fileConfig); err != nil {
+		return err
+	}
+
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return fmt.Errorf("unknown keys in %s: %v", path, undecoded)
+	}
+	if md.IsDefined("signalk-logger", "ignoreSources") {
+		cfg.SignalKLogger.IgnoreSources = fileConfig.SignalKLogger.IgnoreSources
+	}
+
+	for name, value := range setFlags {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("error applying flag -%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Print writes the configuration in the config file format.
+func (cfg *Config) Print(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(cfg)
+}
+
+func (c *NMEALoggerConfig) Validate() error {
+	var errs []error
+	if c.LogDir == "" {
+		errs = append(errs, errors.New("nmealogger.logDir must be set"))
+	}
+	if c.Kplex == "" && c.Gpsd == "" {
+		errs = append(errs, errors.New("nmealogger.kplex or nmealogger.gpsd must be set"))
+	}
+	if c.ServeBuffer <= 0 {
+		errs = append(errs, errors.New("nmealogger.serveBuffer must be positive"))
+	}
+	errs = append(errs, validatePositive("nmealogger.readTimeout", c.ReadTimeout))
+	errs = append(errs, validatePositive("nmealogger.fileRotationInterval", c.FileRotationInterval))
+	if c.TimeSource != "system" && c.TimeSource != "gps" {
+		errs = append(errs, fmt.Errorf("nmealogger.timeSource must be system or gps, not %q", c.TimeSource))
+	}
+	if c.LogFormat != "raw" && c.LogFormat != "jsonl" && c.LogFormat != "both" {
+		errs = append(errs, fmt.Errorf("nmealogger.logFormat must be raw, jsonl or both, not %q", c.LogFormat))
+	}
+	if c.SQLiteRetention.Duration < 0 {
+		errs = append(errs, errors.New("nmealogger.sqliteRetention must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *SignalKLoggerConfig) Validate() error {
+	var errs []error
+	if c.LogDir == "" {
+		errs = append(errs, errors.New("signalk-logger.logDir must be set"))
+	}
+	if c.SignalKAddr == "" {
+		errs = append(errs, errors.New("signalk-logger.signalk-addr must be set"))
+	}
+	if len(c.Subscriptions) == 0 {
+		errs = append(errs, errors.New("signalk-logger.subscriptions must not be empty"))
+	}
+	if len(c.RequiredFields) == 0 {
+		errs = append(errs, errors.New("signalk-logger.requiredFields must not be empty"))
+	}
+	errs = append(errs, validatePositive("signalk-logger.readTimeout", c.ReadTimeout))
+	errs = append(errs, validatePositive("signalk-logger.fileRotationInterval", c.FileRotationInterval))
+	errs = append(errs, validatePositive("signalk-logger.reportingInterval", c.ReportingInterval))
+	errs = append(errs, validatePositive("signalk-logger.missingDataTimeout", c.MissingDataTimeout))
+	errs = append(errs, validatePositive("signalk-logger.staleDataThreshold", c.StaleDataThreshold))
+	if c.SQLiteRetention.Duration < 0 {
+		errs = append(errs, errors.New("signalk-logger.sqliteRetention must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateStorage checks the settings of the selected storage backend.
+func (c *Config) ValidateStorage() error {
+	switch c.Storage.Backend {
+	case "drive":
+		return c.Drive.Validate()
+	case "s3":
+		return c.Storage.S3.Validate()
+	case "webdav":
+		return c.Storage.WebDAV.Validate()
+	case "sftp":
+		return c.Storage.SFTP.Validate()
+	case "dir":
+		if c.Storage.Dir == "" {
+			return errors.New("storage.dir must be set")
+		}
+		return nil
+	default:
+		return fmt.Errorf("storage.backend must be drive, s3, webdav, sftp or dir, got %q", c.Storage.Backend)
+	}
+}
+
+func (c *S3Config) Validate() error {
+	var errs []error
+	if c.Endpoint == "" {
+		errs = append(errs, errors.New("storage.s3.endpoint must be set"))
+	}
+	if c.Bucket == "" {
+		errs = append(errs, errors.New("storage.s3.bucket must be set"))
+	}
+	if (c.AccessKey == "") != (c.SecretKey == "") {
+		errs = append(errs, errors.New("storage.s3.accessKey and storage.s3.secretKey must be set together"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *WebDAVConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("storage.webdav.url must be set")
+	}
+	return nil
+}
+
+func (c *SFTPConfig) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("storage.sftp.addr must be set"))
+	}
+	if c.User == "" {
+		errs = append(errs, errors.New("storage.sftp.user must be set"))
+	}
+	if c.Password == "" && c.KeyFile == "" {
+		errs = append(errs, errors.New("storage.sftp.password or storage.sftp.keyFile must be set"))
+	}
+	if c.KnownHosts == "" {
+		errs = append(errs, errors.New("storage.sftp.knownHosts must be set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *DriveConfig) Validate() error {
+	var errs []error
+	if c.Credentials == "" {
+		errs = append(errs, errors.New("drive.credentials must be set"))
+	}
+	if c.FolderID == "" {
+		errs = append(errs, errors.New("drive.folderId must be set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *LogUploadConfig) Validate() error {
+	var errs []error
+	if c.LogDir == "" {
+		errs = append(errs, errors.New("logupload.logDir must be set"))
+	}
+	errs = append(errs, validatePositive("logupload.fileAgeCutOff", c.FileAgeCutOff))
+	if c.Retries < 0 {
+		errs = append(errs, errors.New("logupload.retries must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *LogDownloadConfig) Validate() error {
+	var errs []error
+	if c.LogDir == "" {
+		errs = append(errs, errors.New("logdownload.logDir must be set"))
+	}
+	if _, err := ParseFilterTime(c.After); err != nil {
+		errs = append(errs, fmt.Errorf("logdownload.after: %w", err))
+	}
+	if _, err := ParseFilterTime(c.Before); err != nil {
+		errs = append(errs, fmt.Errorf("logdownload.before: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseFilterTime parses a UTC date or time given as "2006-01-02" or in
+// ReplayTimeFormat. An empty value gives the zero time.
+func ParseFilterTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(ReplayTimeFormat, value)
+}
+
+func (c *NMEAReplayConfig) Validate() error {
+	var errs []error
+	var startTime, endTime time.Time
+	if c.StartTime != "" {
+		var err error
+		if startTime, err = time.Parse(ReplayTimeFormat, c.StartTime); err != nil {
+			errs = append(errs, fmt.Errorf("nmeareplay.startTime: %w", err))
+		}
+	}
+	if c.EndTime != "" {
+		var err error
+		if endTime, err = time.Parse(ReplayTimeFormat, c.EndTime); err != nil {
+			errs = append(errs, fmt.Errorf("nmeareplay.endTime: %w", err))
+		}
+	}
+	if !startTime.IsZero() && !endTime.IsZero() && !endTime.After(startTime) {
+		errs = append(errs, errors.New("nmeareplay.endTime must be after startTime"))
+	}
+	if c.Speed < 0 {
+		errs = append(errs, errors.New("nmeareplay.speed must not be negative"))
+	}
+	if c.MaxGap.Duration < 0 {
+		errs = append(errs, errors.New("nmeareplay.maxGap must not be negative"))
+	}
+	if c.ListenAddr == "" && c.UDPAddr == "" && !c.Stdout && c.PTY == "" && c.SignalKAddr == "" {
+		errs = append(errs, errors.New("nmeareplay needs an output: listenAddr, udpAddr, stdout, pty or signalkAddr"))
+	}
+	if c.WaitForClient && c.ListenAddr == "" {
+		errs = append(errs, errors.New("nmeareplay.waitForClient needs listenAddr"))
+	}
+	if _, err := ParseSentenceRenames(c.Rename); err != nil {
+		errs = append(errs, fmt.Errorf("nmeareplay.rename: %w", err))
+	}
+	rates := []struct {
+		name  string
+		value float64
+	}{{"dropRate", c.DropRate}, {"corruptRate", c.CorruptRate}, {"delayRate", c.DelayRate}}
+	for _, rate := range rates {
+		if rate.value < 0 || rate.value > 1 {
+			errs = append(errs, fmt.Errorf("nmeareplay.%s must be between 0 and 1", rate.name))
+		}
+	}
+	if c.DelayRate > 0 {
+		errs = append(errs, validatePositive("nmeareplay.delay", c.Delay))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validatePositive(name string, d Duration) error {
+	if d.Duration <= 0 {
+		return fmt.Errorf("%s must be positive", name)
+	}
+	return nil
+}
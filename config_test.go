@@ -0,0 +1,128 @@
+package nmealogger
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "nmealogger.toml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	return path
+}
+
+func TestConfigLoad(t *testing.T) {
+	path := writeConfigFile(t, `
+[nmealogger]
+logDir = "/data"
+kplex = "10.0.0.1:10110"
+readTimeout = "1m"
+
+[signalk-logger]
+ignoreSources = { "navigation.position" = "can0.1" }
+
+[drive]
+folderId = "folder"
+`)
+
+	cfg := DefaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&cfg.NMEALogger.LogDir, "logDir", cfg.NMEALogger.LogDir, "")
+	fs.StringVar(&cfg.NMEALogger.Kplex, "kplex", cfg.NMEALogger.Kplex, "")
+	if err := fs.Parse([]string{"-kplex", "localhost:10110"}); err != nil {
+		t.Fatalf("Error parsing flags: %v", err)
+	}
+
+	if err := cfg.Load(path, fs); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.NMEALogger.LogDir != "/data" {
+		t.Errorf("Expected logDir from config file, got %q", cfg.NMEALogger.LogDir)
+	}
+	if cfg.NMEALogger.Kplex != "localhost:10110" {
+		t.Errorf("Expected kplex from command line, got %q", cfg.NMEALogger.Kplex)
+	}
+	if cfg.NMEALogger.ReadTimeout.Duration != time.Minute {
+		t.Errorf("Expected readTimeout 1m, got %v", cfg.NMEALogger.ReadTimeout)
+	}
+	if cfg.NMEALogger.FileRotationInterval.Duration != 5*time.Minute {
+		t.Errorf("Expected default fileRotationInterval, got %v", cfg.NMEALogger.FileRotationInterval)
+	}
+	if len(cfg.SignalKLogger.IgnoreSources) != 1 || cfg.SignalKLogger.IgnoreSources["navigation.position"] != "can0.1" {
+		t.Errorf("Expected ignoreSources to be replaced, got %v", cfg.SignalKLogger.IgnoreSources)
+	}
+	if cfg.Drive.FolderID != "folder" {
+		t.Errorf("Expected folderId from config file, got %q", cfg.Drive.FolderID)
+	}
+}
+
+func TestConfigLoadUnknownKey(t *testing.T) {
+	path := writeConfigFile(t, `
+[nmealogger]
+logDirectory = "/data"
+`)
+
+	err := DefaultConfig().Load(path, flag.NewFlagSet("test", flag.ContinueOnError))
+	if err == nil || !strings.Contains(err.Error(), "nmealogger.logDirectory") {
+		t.Fatalf("Expected error about unknown key, got %v", err)
+	}
+}
+
+func TestConfigPrintRoundTrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Drive.FolderID = "folder"
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("Error printing config: %v", err)
+	}
+
+	loaded := &Config{}
+	if err := loaded.Load(writeConfigFile(t, buf.String()), flag.NewFlagSet("test", flag.ContinueOnError)); err != nil {
+		t.Fatalf("Error loading printed config: %v", err)
+	}
+
+	if loaded.Drive.FolderID != "folder" || loaded.NMEALogger.FileRotationInterval != cfg.NMEALogger.FileRotationInterval {
+		t.Errorf("Printed config did not round trip: %+v", loaded)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.NMEALogger.Validate(); err != nil {
+		t.Errorf("Expected default nmealogger config to be valid, got %v", err)
+	}
+	if err := cfg.SignalKLogger.Validate(); err != nil {
+		t.Errorf("Expected default signalk-logger config to be valid, got %v", err)
+	}
+	if err := cfg.Drive.Validate(); err == nil {
+		t.Errorf("Expected drive config without folderId to be invalid")
+	}
+	if err := cfg.ValidateStorage(); err == nil || !strings.Contains(err.Error(), "drive.folderId") {
+		t.Errorf("Expected the default drive storage to need folderId, got %v", err)
+	}
+	cfg.Storage.Backend = "s3"
+	cfg.Storage.S3 = S3Config{Endpoint: "s3.eu-north-1.amazonaws.com", Bucket: "logs", AccessKey: "key"}
+	if err := cfg.ValidateStorage(); err == nil || !strings.Contains(err.Error(), "secretKey") {
+		t.Errorf("Expected an error for accessKey without secretKey, got %v", err)
+	}
+	cfg.Storage.Backend = "ftp"
+	if err := cfg.ValidateStorage(); err == nil {
+		t.Errorf("Expected an unknown storage backend to be invalid")
+	}
+
+	cfg.NMEALogger.ReadTimeout.Duration = 0
+	cfg.NMEALogger.Kplex = ""
+	err := cfg.NMEALogger.Validate()
+	if err == nil || !strings.Contains(err.Error(), "readTimeout") || !strings.Contains(err.Error(), "kplex") {
+		t.Errorf("Expected errors for readTimeout and kplex, got %v", err)
+	}
+}
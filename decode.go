@@ -0,0 +1,213 @@
+package nmealogger
+
+import (
+	"strconv"
+	"time"
+)
+
+type fieldKind int
+
+const (
+	skipField fieldKind = iota
+	stringField
+	numberField
+	intField
+	// Latitude and longitude in ddmm.mm format followed by the hemisphere field
+	latitudeField
+	longitudeField
+	// hhmmss.ss time of day
+	timeField
+	// ddmmyy date
+	dateField
+)
+
+type fieldSpec struct {
+	name string
+	kind fieldKind
+}
+
+// The fields of the supported sentences after the address field. Unit fields
+// that are always the same are skipped.
+var sentenceFormats = map[string][]fieldSpec{
+	// $GPRMC,hhmmss.ss,A,llll.ll,a,yyyyy.yy,a,x.x,x.x,ddmmyy,x.x,a,a*hh
+	"RMC": {{"time", timeField}, {"status", stringField}, {"latitude", latitudeField}, {}, {"longitude", longitudeField}, {},
+		{"speedOverGround", numberField}, {"courseOverGround", numberField}, {"date", dateField},
+		{"magneticVariation", numberField}, {"magneticVariationDirection", stringField}, {"mode", stringField}},
+	// $GPGGA,hhmmss.ss,llll.ll,a,yyyyy.yy,a,x,xx,x.x,x.x,M,x.x,M,x.x,xxxx*hh
+	"GGA": {{"time", timeField}, {"latitude", latitudeField}, {}, {"longitude", longitudeField}, {},
+		{"quality", intField}, {"satellites", intField}, {"hdop", numberField}, {"altitude", numberField}, {},
+		{"geoidSeparation", numberField}, {}, {"dgpsAge", numberField}, {"dgpsStation", stringField}},
+	// $GPGLL,llll.ll,a,yyyyy.yy,a,hhmmss.ss,A,a*hh
+	"GLL": {{"latitude", latitudeField}, {}, {"longitude", longitudeField}, {},
+		{"time", timeField}, {"status", stringField}, {"mode", stringField}},
+	// $IIVHW,x.x,T,x.x,M,x.x,N,x.x,K*hh
+	"VHW": {{"headingTrue", numberField}, {}, {"headingMagnetic", numberField}, {},
+		{"speedKnots", numberField}, {}, {"speedKmh", numberField}},
+	// $IIVLW,x.x,N,x.x,N*hh
+	"VLW": {{"totalDistance", numberField}, {}, {"tripDistance", numberField}},
+	// $IIMWV,x.x,a,x.x,a,A*hh
+	"MWV": {{"windAngle", numberField}, {"reference", stringField}, {"windSpeed", numberField},
+		{"windSpeedUnit", stringField}, {"status", stringField}},
+	// $IIVWR,x.x,a,x.x,N,x.x,M,x.x,K*hh
+	"VWR": {{"windAngle", numberField}, {"windSide", stringField}, {"windSpeedKnots", numberField}, {},
+		{"windSpeedMs", numberField}, {}, {"windSpeedKmh", numberField}},
+	// $IIDPT,x.x,x.x,x.x*hh
+	"DPT": {{"depth", numberField}, {"offset", numberField}, {"maxRange", numberField}},
+	// $IIDBT,x.x,f,x.x,M,x.x,F*hh
+	"DBT": {{"depthFeet", numberField}, {}, {"depthMeters", numberField}, {}, {"depthFathoms", numberField}},
+	// $IIHDG,x.x,x.x,a,x.x,a*hh
+	"HDG": {{"heading", numberField}, {"deviation", numberField}, {"deviationDirection", stringField},
+		{"variation", numberField}, {"variationDirection", stringField}},
+	// $IIHDM,x.x,M*hh
+	"HDM": {{"headingMagnetic", numberField}},
+	// $IIHDT,x.x,T*hh
+	"HDT": {{"headingTrue", numberField}},
+	// $IIMTW,x.x,C*hh
+	"MTW": {{"temperature", numberField}, {"temperatureUnit", stringField}},
+	// $IIROT,x.x,A*hh, degrees per minute, negative to port
+	"ROT": {{"rateOfTurn", numberField}, {"status", stringField}},
+	// $GPZDA,hhmmss.ss,dd,mm,yyyy,zh,zm*hh
+	"ZDA": {{"time", timeField}, {"day", intField}, {"month", intField}, {"year", intField},
+		{"zoneHours", intField}, {"zoneMinutes", intField}},
+	// $GPVTG,x.x,T,x.x,M,x.x,N,x.x,K,a*hh
+	"VTG": {{"courseTrue", numberField}, {}, {"courseMagnetic", numberField}, {},
+		{"speedKnots", numberField}, {}, {"speedKmh", numberField}, {}, {"mode", stringField}},
+}
+
+// DecodeSentence returns the fields of a supported sentence by name, with
+// numbers as float64 or int, positions in decimal degrees, times as
+// "15:04:05.999" and dates as "2006-01-02". Empty fields are left out, as are
+// fields that can't be parsed. XDR sentences have their measurements in a
+// list under "measurements".
+//
+// The second return value is false if the sentence type isn't supported.
+func DecodeSentence(sentence string) (map[string]any, bool) {
+	_, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return nil, false
+	}
+
+	fields := SentenceFields(sentence)
+	if sentenceType == "XDR" {
+		return decodeXDR(fields[1:]), true
+	}
+
+	format, ok := sentenceFormats[sentenceType]
+	if !ok {
+		return nil, false
+	}
+
+	decoded := make(map[string]any)
+	for i, spec := range format {
+		if i+1 >= len(fields) {
+			break
+		}
+		field := fields[i+1]
+		if spec.kind == skipField || field == "" {
+			continue
+		}
+
+		var hemisphere string
+		if i+2 < len(fields) {
+			hemisphere = fields[i+2]
+		}
+		if value, ok := decodeField(spec.kind, field, hemisphere); ok {
+			decoded[spec.name] = value
+		}
+	}
+
+	return decoded, true
+}
+
+func decodeField(kind fieldKind, field, hemisphere string) (any, bool) {
+	switch kind {
+	case stringField:
+		return field, true
+	case numberField:
+		value, err := strconv.ParseFloat(field, 64)
+		return value, err == nil
+	case intField:
+		value, err := strconv.Atoi(field)
+		return value, err == nil
+	case latitudeField:
+		return parseCoordinate(field, 2, hemisphere, "N", "S")
+	case longitudeField:
+		return parseCoordinate(field, 3, hemisphere, "E", "W")
+	case timeField:
+		hour, min, sec, nsec, ok := parseTimeOfDay(field)
+		if !ok {
+			return nil, false
+		}
+		t := time.Date(0, 1, 1, hour, min, sec, nsec, time.UTC).Round(time.Millisecond)
+		return t.Format("15:04:05.999"), true
+	case dateField:
+		t, err := time.Parse("020106", field)
+		if err != nil {
+			return nil, false
+		}
+		return t.Format("2006-01-02"), true
+	}
+
+	return nil, false
+}
+
+// parseCoordinate converts a ddmm.mm or dddmm.mm coordinate to decimal
+// degrees, negative for the southern and western hemispheres.
+func parseCoordinate(field string, degreeDigits int, hemisphere, positive, negative string) (float64, bool) {
+	if len(field) < degreeDigits+2 || (hemisphere != positive && hemisphere != negative) {
+		return 0, false
+	}
+
+	degrees, errDegrees := strconv.Atoi(field[:degreeDigits])
+	minutes, errMinutes := strconv.ParseFloat(field[degreeDigits:], 64)
+	if errDegrees != nil || errMinutes != nil || minutes < 0 || minutes >= 60 {
+		return 0, false
+	}
+
+	value := float64(degrees) + minutes/60
+	if hemisphere == negative {
+		value = -value
+	}
+	return value, true
+}
+
+// decodeXDR decodes the transducer measurements of an XDR sentence, which
+// come in groups of type, value, unit and name:
+// $IIXDR,C,19.52,C,TempAir,P,1.02481,B,Barometer*hh
+func decodeXDR(fields []string) map[string]any {
+	var measurements []map[string]any
+	for i := 0; i+3 < len(fields); i += 4 {
+		measurement := map[string]any{
+			"type": fields[i],
+			"unit": fields[i+2],
+			"name": fields[i+3],
+		}
+		if value, err := strconv.ParseFloat(fields[i+1], 64); err == nil {
+			measurement["value"] = value
+		}
+		measurements = append(measurements, measurement)
+	}
+
+	return map[string]any{"measurements": measurements}
+}
+
+// ParsePosition returns the position in decimal degrees from RMC and GLL
+// sentences with a valid status and GGA sentences with a fix.
+func ParsePosition(sentence string) (latitude, longitude float64, ok bool) {
+	_, sentenceType, ok := SentenceID(sentence)
+	if !ok || (sentenceType != "RMC" && sentenceType != "GLL" && sentenceType != "GGA") {
+		return 0, 0, false
+	}
+
+	fields, _ := DecodeSentence(sentence)
+	if status, ok := fields["status"]; ok && status != "A" {
+		return 0, 0, false
+	}
+	if quality, ok := fields["quality"]; ok && quality == 0 {
+		return 0, 0, false
+	}
+
+	latitude, latOk := fields["latitude"].(float64)
+	longitude, lonOk := fields["longitude"].(float64)
+	return latitude, longitude, latOk && lonOk
+}
@@ -0,0 +1,91 @@
+package nmealogger
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeSentence(t *testing.T) {
+	tests := []struct {
+		sentence string
+		expected map[string]any
+	}{
+		{"$GPRMC,130949,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*1F", map[string]any{
+			"time": "13:09:49", "status": "A", "latitude": 59.516167, "longitude": 24.771917,
+			"speedOverGround": 5.7, "courseOverGround": 160.0, "date": "2024-07-15",
+			"magneticVariation": 0.0, "magneticVariationDirection": "E", "mode": "A",
+		}},
+		{"$GPGLL,5930.970,S,02446.315,W,130949.50,A,A*43", map[string]any{
+			"latitude": -59.516167, "longitude": -24.771917, "time": "13:09:49.5", "status": "A", "mode": "A",
+		}},
+		{"$GPGGA,130949,5930.970,N,02446.315,E,1,08,0.9,12.5,M,18.2,M,,*47", map[string]any{
+			"time": "13:09:49", "latitude": 59.516167, "longitude": 24.771917, "quality": 1,
+			"satellites": 8, "hdop": 0.9, "altitude": 12.5, "geoidSeparation": 18.2,
+		}},
+		{"$IIVHW,,,117,M,05.7,N,,*61", map[string]any{"headingMagnetic": 117.0, "speedKnots": 5.7}},
+		{"$IIVLW,09452,N,030.8,N*52", map[string]any{"totalDistance": 9452.0, "tripDistance": 30.8}},
+		{"$IIMWV,127,R,21.8,N,A*1C", map[string]any{
+			"windAngle": 127.0, "reference": "R", "windSpeed": 21.8, "windSpeedUnit": "N", "status": "A",
+		}},
+		{"$IIVWR,154,R,05.5,N,,,,*61", map[string]any{"windAngle": 154.0, "windSide": "R", "windSpeedKnots": 5.5}},
+		{"$IIDPT,012.3,-0.5,*6A", map[string]any{"depth": 12.3, "offset": -0.5}},
+		{"$IIMTW,19.5,C*25", map[string]any{"temperature": 19.5, "temperatureUnit": "C"}},
+		{"$GPZDA,130949.00,15,07,2024,00,00*6F", map[string]any{
+			"time": "13:09:49", "day": 15, "month": 7, "year": 2024, "zoneHours": 0, "zoneMinutes": 0,
+		}},
+		{"$IIXDR,C,19.52,C,TempAir,P,1.02481,B,Barometer*2F", map[string]any{
+			"measurements": []map[string]any{
+				{"type": "C", "value": 19.52, "unit": "C", "name": "TempAir"},
+				{"type": "P", "value": 1.02481, "unit": "B", "name": "Barometer"},
+			},
+		}},
+	}
+
+	for _, test := range tests {
+		decoded, ok := DecodeSentence(test.sentence)
+		if !ok {
+			t.Errorf("Expected %s to be decoded", test.sentence)
+			continue
+		}
+		if !reflect.DeepEqual(roundFloats(decoded), test.expected) {
+			t.Errorf("Decoded %s to %v, expected %v", test.sentence, decoded, test.expected)
+		}
+	}
+
+	if _, ok := DecodeSentence("$PGRME,15.0,M,45.0,M,25.0,M*1C"); ok {
+		t.Errorf("Expected unsupported sentence not to be decoded")
+	}
+}
+
+func TestNewJSONLogEntry(t *testing.T) {
+	received := time.Date(2024, 7, 15, 13, 9, 49, 217000000, time.UTC)
+	entry := NewJSONLogEntry(received, "127.0.0.1:10110", "$IIHDM,117,M*3A")
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("Error marshaling entry: %v", err)
+	}
+	expected := `{"time":"2024-07-15T13:09:49.217Z","source":"127.0.0.1:10110","sentence":"$IIHDM,117,M*3A",` +
+		`"talker":"II","type":"HDM","fields":{"headingMagnetic":117}}`
+	if string(data) != expected {
+		t.Errorf("Unexpected JSON:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	comment := NewJSONLogEntry(received, "127.0.0.1:10110", "# Clock jumped")
+	if comment.Source != "" || comment.Type != "" || comment.Fields != nil {
+		t.Errorf("Expected only the time and comment for annotations, got %+v", comment)
+	}
+}
+
+// roundFloats rounds the decoded coordinates so that they can be compared.
+func roundFloats(decoded map[string]any) map[string]any {
+	for key, value := range decoded {
+		if f, ok := value.(float64); ok {
+			decoded[key] = math.Round(f*1e6) / 1e6
+		}
+	}
+	return decoded
+}
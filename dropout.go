@@ -0,0 +1,190 @@
+package nmealogger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// A stream is only monitored once its rate has been learned from this
+	// many sentences
+	StreamLearningSamples = 20
+	// A stream has dropped out when there's been no sentence for this many
+	// times the normal interval, but at least MinStreamSilence
+	StreamSilenceFactor = 10
+	MinStreamSilence    = 5 * time.Second
+	// The rate is checked over this many normal intervals, but at least
+	// MinStreamRateWindow
+	StreamRateWindowIntervals = 20
+	MinStreamRateWindow       = 10 * time.Second
+	// The rate has collapsed when it falls below this fraction of the normal
+	// rate and recovered when it's back above StreamRateRecovered
+	StreamRateCollapsed = 0.25
+	StreamRateRecovered = 0.5
+
+	// Weight of a new interval in the moving average of the normal interval
+	streamIntervalAlpha = 0.05
+)
+
+const (
+	// The stream went silent
+	StreamDropout = "dropout"
+	// The stream still has data but at a fraction of the normal rate
+	StreamDegraded = "degraded"
+	// The stream is back to normal after a dropout or a degraded rate
+	StreamRecovered = "recovered"
+)
+
+// StreamEvent is a change in the state of a sentence stream.
+type StreamEvent struct {
+	Time time.Time
+	// Talker and sentence type, eg. IIMWV
+	Stream string
+	// StreamDropout, StreamDegraded or StreamRecovered
+	Event string
+	// The normal interval between sentences of the stream
+	NormalInterval time.Duration
+	// For dropouts and recovery from them, how long the stream was silent
+	Silence time.Duration
+	// For degraded streams and recovery from it, the rate in the last window
+	// as a fraction of the normal rate
+	RateRatio float64
+}
+
+func (e StreamEvent) String() string {
+	normal := e.NormalInterval.Round(time.Millisecond)
+	switch {
+	case e.Event == StreamDropout:
+		return fmt.Sprintf("%s %s: no data for %v, normally every %v", e.Stream, e.Event, e.Silence.Round(time.Second), normal)
+	case e.Event == StreamDegraded:
+		return fmt.Sprintf("%s %s: rate at %.0f%% of normal, normally every %v", e.Stream, e.Event, e.RateRatio*100, normal)
+	case e.Silence > 0:
+		return fmt.Sprintf("%s %s after %v of silence", e.Stream, e.Event, e.Silence.Round(time.Second))
+	default:
+		return fmt.Sprintf("%s %s: rate at %.0f%% of normal", e.Stream, e.Event, e.RateRatio*100)
+	}
+}
+
+type streamState struct {
+	samples  int
+	interval time.Duration
+	lastSeen time.Time
+	// "" when the stream is normal, otherwise StreamDropout or StreamDegraded
+	state       string
+	windowStart time.Time
+	windowCount int
+}
+
+// StreamMonitor learns the normal rate of each sentence stream and detects
+// when a stream goes silent or its rate collapses, eg. when an instrument
+// stops reporting.
+type StreamMonitor struct {
+	streams map[string]*streamState
+}
+
+func NewStreamMonitor() *StreamMonitor {
+	return &StreamMonitor{streams: make(map[string]*streamState)}
+}
+
+// Observe records a sentence received at time t and returns the recovery
+// event if the stream was in a dropout.
+func (m *StreamMonitor) Observe(sentence string, t time.Time) []StreamEvent {
+	talker, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return nil
+	}
+	name := talker + sentenceType
+
+	s, ok := m.streams[name]
+	if !ok {
+		m.streams[name] = &streamState{samples: 1, lastSeen: t, windowStart: t, windowCount: 1}
+		return nil
+	}
+
+	var events []StreamEvent
+	interval := t.Sub(s.lastSeen)
+	if s.state == StreamDropout {
+		events = append(events, StreamEvent{
+			Time:           t,
+			Stream:         name,
+			Event:          StreamRecovered,
+			NormalInterval: s.interval,
+			Silence:        interval,
+		})
+		s.state = ""
+		s.windowStart = t
+		s.windowCount = 0
+	} else if s.state == "" {
+		// Learn only from the normal rate
+		if s.samples == 1 {
+			s.interval = interval
+		} else {
+			s.interval += time.Duration(streamIntervalAlpha * float64(interval-s.interval))
+		}
+		s.samples++
+	}
+
+	s.lastSeen = t
+	s.windowCount++
+	return events
+}
+
+// Check returns the events for streams that have gone silent or whose rate
+// has collapsed or recovered by time t. It should be called regularly.
+func (m *StreamMonitor) Check(t time.Time) []StreamEvent {
+	var events []StreamEvent
+	for name, s := range m.streams {
+		if s.samples < StreamLearningSamples || s.state == StreamDropout {
+			continue
+		}
+
+		silence := t.Sub(s.lastSeen)
+		if silence > max(StreamSilenceFactor*s.interval, MinStreamSilence) {
+			s.state = StreamDropout
+			events = append(events, StreamEvent{
+				Time:           t,
+				Stream:         name,
+				Event:          StreamDropout,
+				NormalInterval: s.interval,
+				Silence:        silence,
+			})
+			continue
+		}
+
+		window := t.Sub(s.windowStart)
+		if window < max(StreamRateWindowIntervals*s.interval, MinStreamRateWindow) {
+			continue
+		}
+
+		ratio := float64(s.windowCount) * float64(s.interval) / float64(window)
+		event := StreamEvent{Time: t, Stream: name, NormalInterval: s.interval, RateRatio: ratio}
+		if s.state == "" && ratio < StreamRateCollapsed {
+			s.state = StreamDegraded
+			event.Event = StreamDegraded
+			events = append(events, event)
+		} else if s.state == StreamDegraded && ratio >= StreamRateRecovered {
+			s.state = ""
+			event.Event = StreamRecovered
+			events = append(events, event)
+		}
+		s.windowStart = t
+		s.windowCount = 0
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Stream < events[j].Stream })
+	return events
+}
+
+// Restart is called when the input reconnects at time t. The learned rates
+// are kept but the time spent disconnected isn't counted as silence.
+func (m *StreamMonitor) Restart(t time.Time) {
+	for _, s := range m.streams {
+		if s.state == StreamDropout {
+			continue
+		}
+		s.lastSeen = t
+		s.windowStart = t
+		s.windowCount = 0
+	}
+}
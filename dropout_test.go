@@ -0,0 +1,77 @@
+package nmealogger
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testWindSentence  = "$IIMWV,127,R,21.8,N,A*1C"
+	testSpeedSentence = "$IIVHW,,,117,M,05.7,N,,*61"
+)
+
+func TestStreamMonitorDropout(t *testing.T) {
+	m := NewStreamMonitor()
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+
+	// Wind and speed at 1Hz for a minute, then the wind stops for 30s
+	var events []StreamEvent
+	for i := 0; i < 120; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		if i < 60 || i >= 90 {
+			events = append(events, m.Observe(testWindSentence, now)...)
+		}
+		events = append(events, m.Observe(testSpeedSentence, now)...)
+		events = append(events, m.Check(now)...)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected a dropout and recovery, got %v", events)
+	}
+	if e := events[0]; e.Stream != "IIMWV" || e.Event != StreamDropout || !e.Time.Equal(start.Add(70*time.Second)) {
+		t.Errorf("Expected IIMWV dropout after 10s, got %v at %v", e, e.Time)
+	}
+	if e := events[1]; e.Stream != "IIMWV" || e.Event != StreamRecovered || e.Silence != 31*time.Second {
+		t.Errorf("Expected IIMWV recovery after 31s, got %v", e)
+	}
+	if e := events[0]; e.NormalInterval != time.Second {
+		t.Errorf("Expected a normal interval of 1s, got %v", e.NormalInterval)
+	}
+}
+
+func TestStreamMonitorDegraded(t *testing.T) {
+	m := NewStreamMonitor()
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+
+	// 10Hz for a minute, then once every 4s for a minute and back to 10Hz
+	var events []StreamEvent
+	for i := 0; i < 1800; i++ {
+		now := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		if i < 600 || i >= 1200 || i%40 == 0 {
+			events = append(events, m.Observe(testWindSentence, now)...)
+		}
+		events = append(events, m.Check(now)...)
+	}
+
+	if len(events) != 2 || events[0].Event != StreamDegraded || events[1].Event != StreamRecovered {
+		t.Fatalf("Expected degraded and recovered events, got %v", events)
+	}
+	if events[0].RateRatio > StreamRateCollapsed {
+		t.Errorf("Expected rate below %v, got %v", StreamRateCollapsed, events[0].RateRatio)
+	}
+}
+
+func TestStreamMonitorRestart(t *testing.T) {
+	m := NewStreamMonitor()
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		m.Observe(testWindSentence, start.Add(time.Duration(i)*time.Second))
+	}
+
+	// Disconnected for a minute
+	reconnected := start.Add(90 * time.Second)
+	m.Restart(reconnected)
+	if events := m.Check(reconnected.Add(time.Second)); len(events) != 0 {
+		t.Errorf("Expected no events after reconnecting, got %v", events)
+	}
+}
@@ -0,0 +1,67 @@
+package nmealogger
+
+import (
+	"sync"
+	"time"
+)
+
+// MaxGPSClockError is how far ahead of the GPS time the clock is allowed to
+// drift before it is stepped back.
+const MaxGPSClockError = 2 * time.Second
+
+// GPSClock is a clock that is disciplined by the time received from GPS. It
+// runs from the monotonic clock between fixes, so it's unaffected by the
+// system clock being wrong or stepped by NTP.
+type GPSClock struct {
+	mu        sync.Mutex
+	synced    bool
+	gpsTime   time.Time // GPS time at the last adjustment
+	localTime time.Time // local time at the last adjustment, with a monotonic reading
+}
+
+// Now returns the current GPS time and whether the clock has been synced. If
+// it hasn't, the system time is returned.
+func (c *GPSClock) Now() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.at(time.Now())
+}
+
+// at must be called with c.mu held.
+func (c *GPSClock) at(localTime time.Time) (time.Time, bool) {
+	if !c.synced {
+		return localTime, false
+	}
+	return c.gpsTime.Add(localTime.Sub(c.localTime)), true
+}
+
+// Sync adjusts the clock with a GPS time that was received at localTime,
+// which needs to have a monotonic clock reading (ie. come from time.Now).
+// Returns how much the clock was adjusted by, 0 for the first sync.
+//
+// GPS times in sentences are usually truncated to whole seconds and arrive
+// some time later, so they're a lower bound on the true time. The clock is
+// moved forward whenever the GPS time is ahead of it, but only moved back if
+// it's ahead of the GPS time by more than MaxGPSClockError.
+func (c *GPSClock) Sync(gpsTime time.Time, localTime time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.synced {
+		c.synced = true
+		c.gpsTime = gpsTime
+		c.localTime = localTime
+		return 0
+	}
+
+	current, _ := c.at(localTime)
+	adjustment := gpsTime.Sub(current)
+	if adjustment > 0 || adjustment < -MaxGPSClockError {
+		c.gpsTime = gpsTime
+		c.localTime = localTime
+		return adjustment
+	}
+
+	return 0
+}
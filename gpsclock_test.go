@@ -0,0 +1,40 @@
+package nmealogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGPSClock(t *testing.T) {
+	var clock GPSClock
+
+	if _, synced := clock.Now(); synced {
+		t.Fatal("Expected new clock not to be synced")
+	}
+
+	local := time.Now()
+	gps := time.Date(2024, 7, 15, 13, 9, 49, 0, time.UTC)
+	if adjustment := clock.Sync(gps, local); adjustment != 0 {
+		t.Fatalf("Expected no adjustment for the first sync, got %v", adjustment)
+	}
+
+	now, synced := clock.Now()
+	if !synced || now.Before(gps) || now.Sub(gps) > time.Second {
+		t.Fatalf("Expected clock to run from GPS time %v, got %v", gps, now)
+	}
+
+	// GPS time lagging by less than the max error doesn't move the clock back
+	if adjustment := clock.Sync(gps.Add(500*time.Millisecond), local.Add(time.Second)); adjustment != 0 {
+		t.Fatalf("Expected lagging GPS time to be ignored, got adjustment %v", adjustment)
+	}
+
+	// GPS time ahead of the clock moves it forward
+	if adjustment := clock.Sync(gps.Add(2300*time.Millisecond), local.Add(2*time.Second)); adjustment != 300*time.Millisecond {
+		t.Fatalf("Expected clock to be moved forward by 300ms, got %v", adjustment)
+	}
+
+	// GPS time far behind the clock steps it back
+	if adjustment := clock.Sync(gps.Add(-time.Hour), local.Add(3*time.Second)); adjustment != -time.Hour-3300*time.Millisecond {
+		t.Fatalf("Expected clock to be stepped back, got %v", adjustment)
+	}
+}
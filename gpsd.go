@@ -0,0 +1,190 @@
+package nmealogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// GpsdWatchCommand asks gpsd to stream JSON reports along with the NMEA
+// sentences it receives from the devices.
+const GpsdWatchCommand = `?WATCH={"enable":true,"json":true,"nmea":true};` + "\n"
+
+// Knots per meter per second
+const knotsPerMS = 3600.0 / 1852.0
+
+// GpsdReader reads sentences from a gpsd connection that has been sent the
+// GpsdWatchCommand. Raw NMEA is passed through as is. If gpsd doesn't provide
+// NMEA, eg. for devices that speak a binary protocol, RMC and GGA sentences
+// are synthesized from the TPV reports instead.
+type GpsdReader struct {
+	reader  *bufio.Reader
+	pending []string
+	// Set when NMEA is received and cleared on each TPV, so that TPV reports
+	// are only converted if there was no NMEA since the previous one
+	nmeaReceived bool
+	// Satellite info from the latest SKY report, used in GGA
+	satellites int
+	hdop       float64
+}
+
+// gpsdReport holds the fields of TPV and SKY reports that we use.
+type gpsdReport struct {
+	Class string  `json:"class"`
+	Mode  int     `json:"mode"`
+	Time  string  `json:"time"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	// Altitude above mean sea level, older versions of gpsd only have alt
+	AltMSL *float64 `json:"altMSL"`
+	Alt    *float64 `json:"alt"`
+	// Speed in m/s and course in degrees true
+	Speed *float64 `json:"speed"`
+	Track *float64 `json:"track"`
+
+	HDOP       float64 `json:"hdop"`
+	USat       *int    `json:"uSat"`
+	Satellites []struct {
+		Used bool `json:"used"`
+	} `json:"satellites"`
+}
+
+func NewGpsdReader(r io.Reader) *GpsdReader {
+	return &GpsdReader{reader: bufio.NewReader(r)}
+}
+
+// ReadSentence returns the next NMEA sentence from gpsd, skipping the JSON
+// reports that don't translate to sentences or can't be parsed.
+func (g *GpsdReader) ReadSentence() (string, error) {
+	for len(g.pending) == 0 {
+		data, err := g.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line := strings.TrimRight(data, "\r\n")
+		if line == "" {
+			continue
+		}
+		if line[0] != '{' {
+			g.nmeaReceived = true
+			return line, nil
+		}
+
+		var report gpsdReport
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			continue
+		}
+		g.pending = g.processReport(&report)
+	}
+
+	sentence := g.pending[0]
+	g.pending = g.pending[1:]
+	return sentence, nil
+}
+
+func (g *GpsdReader) processReport(report *gpsdReport) []string {
+	switch report.Class {
+	case "SKY":
+		g.hdop = report.HDOP
+		if report.USat != nil {
+			g.satellites = *report.USat
+		} else if report.Satellites != nil {
+			g.satellites = 0
+			for _, satellite := range report.Satellites {
+				if satellite.Used {
+					g.satellites++
+				}
+			}
+		}
+	case "TPV":
+		nmeaReceived := g.nmeaReceived
+		g.nmeaReceived = false
+		if !nmeaReceived {
+			return g.synthesize(report)
+		}
+	}
+
+	return nil
+}
+
+// synthesize converts a TPV report to RMC and GGA sentences. Reports without
+// a time are skipped as the sentences can't be timestamped.
+func (g *GpsdReader) synthesize(tpv *gpsdReport) []string {
+	t, err := time.Parse(time.RFC3339Nano, tpv.Time)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+
+	fix := tpv.Mode >= 2
+	var latitude, longitude string
+	if fix {
+		latitude = formatCoordinate(tpv.Lat, 2, "N", "S")
+		longitude = formatCoordinate(tpv.Lon, 3, "E", "W")
+	} else {
+		latitude, longitude = ",", ","
+	}
+
+	// $GPRMC,hhmmss.ss,A,llll.ll,a,yyyyy.yy,a,x.x,x.x,ddmmyy,x.x,a,a*hh
+	status, mode := "V", "N"
+	if fix {
+		status, mode = "A", "A"
+	}
+	rmc := fmt.Sprintf("GPRMC,%s,%s,%s,%s,%s,%s,%s,,,%s",
+		t.Format("150405.00"), status, latitude, longitude,
+		formatOptional(tpv.Speed, knotsPerMS, 1), formatOptional(tpv.Track, 1, 1), t.Format("020106"), mode)
+
+	// $GPGGA,hhmmss.ss,llll.ll,a,yyyyy.yy,a,x,xx,x.x,x.x,M,x.x,M,x.x,xxxx*hh
+	quality := 0
+	if fix {
+		quality = 1
+	}
+	altitude := tpv.AltMSL
+	if altitude == nil {
+		altitude = tpv.Alt
+	}
+	if tpv.Mode < 3 {
+		altitude = nil
+	}
+	hdop := ""
+	if g.hdop > 0 {
+		hdop = fmt.Sprintf("%.1f", g.hdop)
+	}
+	gga := fmt.Sprintf("GPGGA,%s,%s,%s,%d,%02d,%s,%s,M,,M,,",
+		t.Format("150405.00"), latitude, longitude, quality, g.satellites, hdop, formatOptional(altitude, 1, 1))
+
+	return []string{
+		"$" + rmc + "*" + CalculateChecksum(rmc),
+		"$" + gga + "*" + CalculateChecksum(gga),
+	}
+}
+
+// formatCoordinate formats decimal degrees as ddmm.mmmm or dddmm.mmmm and
+// the hemisphere, separated by a comma.
+func formatCoordinate(value float64, degreeDigits int, positive, negative string) string {
+	hemisphere := positive
+	if value < 0 {
+		hemisphere = negative
+		value = -value
+	}
+
+	// Round to the precision of the output so that minutes don't become 60
+	totalMinutes := math.Round(value*60*1e4) / 1e4
+	degrees := int(totalMinutes / 60)
+	minutes := totalMinutes - float64(degrees*60)
+	return fmt.Sprintf("%0*d%07.4f,%s", degreeDigits, degrees, minutes, hemisphere)
+}
+
+// formatOptional formats the value multiplied by scale, or an empty field if
+// the value is missing.
+func formatOptional(value *float64, scale float64, decimals int) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.*f", decimals, *value*scale)
+}
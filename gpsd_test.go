@@ -0,0 +1,85 @@
+package nmealogger
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeGpsd accepts a single connection, waits for the watch command and
+// then sends the reports.
+func startFakeGpsd(t *testing.T, reports []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(`{"class":"VERSION","release":"3.22","proto_major":3,"proto_minor":14}` + "\n"))
+		command, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || command != GpsdWatchCommand {
+			t.Errorf("Expected watch command, got %q (%v)", command, err)
+			return
+		}
+		for _, report := range reports {
+			conn.Write([]byte(report + "\r\n"))
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestGpsdReader(t *testing.T) {
+	addr := startFakeGpsd(t, []string{
+		`{"class":"DEVICES","devices":[{"class":"DEVICE","path":"/dev/ttyACM0","driver":"u-blox"}]}`,
+		`{"class":"WATCH","enable":true,"json":true,"nmea":true}`,
+		`{"class":"SKY","hdop":0.9,"satellites":[{"PRN":1,"used":true},{"PRN":2,"used":false},{"PRN":3,"used":true}]}`,
+		`{"class":"TPV","mode":3,"time":"2024-07-15T13:09:49.000Z","lat":59.516167,"lon":-24.771917,"altMSL":12.5,"speed":2.932,"track":160.0}`,
+		`{"class":"TPV","mode":1,"time":"2024-07-15T13:09:50.000Z"}`,
+		`$GPRMC,130951,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*16`,
+		`{"class":"TPV","mode":3,"time":"2024-07-15T13:09:51.000Z","lat":59.516167,"lon":24.771917}`,
+		`$GPRMC,130952,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*15`,
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to gpsd: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := conn.Write([]byte(GpsdWatchCommand)); err != nil {
+		t.Fatalf("Error sending watch command: %v", err)
+	}
+
+	expected := []string{
+		"$GPRMC,130949.00,A,5930.9700,N,02446.3150,W,5.7,160.0,150724,,,A*48",
+		"$GPGGA,130949.00,5930.9700,N,02446.3150,W,1,02,0.9,12.5,M,,M,,*59",
+		"$GPRMC,130950.00,V,,,,,,,150724,,,N*76",
+		"$GPGGA,130950.00,,,,,0,02,0.9,,M,,M,,*63",
+		"$GPRMC,130951,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*16",
+		"$GPRMC,130952,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*15",
+	}
+
+	reader := NewGpsdReader(conn)
+	for _, want := range expected {
+		sentence, err := reader.ReadSentence()
+		if err != nil {
+			t.Fatalf("Error reading sentence: %v", err)
+		}
+		if sentence != want {
+			t.Errorf("Expected %s, got %s", want, sentence)
+		}
+		if !HasValidChecksum(sentence) {
+			t.Errorf("Invalid checksum in %s", sentence)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package nmealogger
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseGPSTime returns the UTC date and time contained in an RMC or ZDA
+// sentence. Other sentences, RMC sentences with a void status and sentences
+// with missing or malformed fields are not considered to have a time.
+func ParseGPSTime(sentence string) (time.Time, bool) {
+	_, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	fields := SentenceFields(sentence)
+	switch sentenceType {
+	case "RMC":
+		// $GPRMC,hhmmss.ss,A,llll.ll,a,yyyyy.yy,a,x.x,x.x,ddmmyy,x.x,a*hh
+		if len(fields) < 10 || fields[2] != "A" {
+			return time.Time{}, false
+		}
+		return rmcDateTime(fields[9], fields[1])
+	case "ZDA":
+		// $GPZDA,hhmmss.ss,dd,mm,yyyy,zh,zm*hh
+		if len(fields) < 5 {
+			return time.Time{}, false
+		}
+		day, errDay := strconv.Atoi(fields[2])
+		month, errMonth := strconv.Atoi(fields[3])
+		year, errYear := strconv.Atoi(fields[4])
+		if errDay != nil || errMonth != nil || errYear != nil {
+			return time.Time{}, false
+		}
+		return gpsDateTime(year, month, day, fields[1])
+	}
+
+	return time.Time{}, false
+}
+
+// ShiftGPSTime moves the time and date fields of an RMC, ZDA, GGA or GLL
+// sentence forward by offset and recomputes the checksum. The precision of
+// the time field is kept. GGA and GLL only have the time of day, the date is
+// taken to be the one that puts it closest to logTime, the time the sentence
+// was logged. Other sentences and sentences with missing or malformed times
+// are returned as is with ok false.
+func ShiftGPSTime(sentence string, logTime time.Time, offset time.Duration) (string, bool) {
+	_, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return sentence, false
+	}
+
+	fields := SentenceFields(sentence)
+	timeIndex := 1
+	var t time.Time
+	switch sentenceType {
+	case "RMC":
+		if len(fields) < 10 {
+			return sentence, false
+		}
+		t, ok = rmcDateTime(fields[9], fields[1])
+	case "ZDA":
+		if len(fields) < 5 {
+			return sentence, false
+		}
+		day, errDay := strconv.Atoi(fields[2])
+		month, errMonth := strconv.Atoi(fields[3])
+		year, errYear := strconv.Atoi(fields[4])
+		if errDay != nil || errMonth != nil || errYear != nil {
+			return sentence, false
+		}
+		t, ok = gpsDateTime(year, month, day, fields[1])
+	case "GGA", "GLL":
+		if sentenceType == "GLL" {
+			// $GPGLL,llll.ll,a,yyyyy.yy,a,hhmmss.ss,A*hh
+			timeIndex = 5
+		}
+		if len(fields) <= timeIndex {
+			return sentence, false
+		}
+		logTime = logTime.UTC()
+		t, ok = gpsDateTime(logTime.Year(), int(logTime.Month()), logTime.Day(), fields[timeIndex])
+		if ok && t.Sub(logTime) > 12*time.Hour {
+			t = t.AddDate(0, 0, -1)
+		} else if ok && logTime.Sub(t) > 12*time.Hour {
+			t = t.AddDate(0, 0, 1)
+		}
+	default:
+		return sentence, false
+	}
+	if !ok {
+		return sentence, false
+	}
+
+	t = t.Add(offset)
+	timeLayout := "150405"
+	if i := strings.IndexByte(fields[timeIndex], '.'); i >= 0 {
+		timeLayout += "." + strings.Repeat("0", len(fields[timeIndex])-i-1)
+	}
+	fields[timeIndex] = t.Format(timeLayout)
+	switch sentenceType {
+	case "RMC":
+		fields[9] = t.Format("020106")
+	case "ZDA":
+		fields[2], fields[3], fields[4] = t.Format("02"), t.Format("01"), t.Format("2006")
+	}
+
+	data := strings.Join(fields, ",")
+	return sentence[:1] + data + "*" + CalculateChecksum(data), true
+}
+
+// rmcDateTime returns the time in the ddmmyy date and hhmmss.ss time fields
+// of an RMC sentence.
+func rmcDateTime(dateField, timeField string) (time.Time, bool) {
+	if len(dateField) != 6 {
+		return time.Time{}, false
+	}
+	day, errDay := strconv.Atoi(dateField[0:2])
+	month, errMonth := strconv.Atoi(dateField[2:4])
+	year, errYear := strconv.Atoi(dateField[4:6])
+	if errDay != nil || errMonth != nil || errYear != nil {
+		return time.Time{}, false
+	}
+	if year < 80 {
+		year += 2000
+	} else {
+		year += 1900
+	}
+	return gpsDateTime(year, month, day, timeField)
+}
+
+// parseTimeOfDay parses the hhmmss.ss time field into its components.
+func parseTimeOfDay(field string) (hour, min, sec, nsec int, ok bool) {
+	if len(field) < 6 {
+		return 0, 0, 0, 0, false
+	}
+
+	hour, errHour := strconv.Atoi(field[0:2])
+	min, errMin := strconv.Atoi(field[2:4])
+	seconds, errSec := strconv.ParseFloat(field[4:], 64)
+	if errHour != nil || errMin != nil || errSec != nil || strings.ContainsAny(field[4:], "+-eE") {
+		return 0, 0, 0, 0, false
+	}
+	if hour > 23 || min > 59 || seconds < 0 || seconds >= 61 {
+		return 0, 0, 0, 0, false
+	}
+
+	sec = int(seconds)
+	nsec = int((seconds - float64(sec)) * 1e9)
+	return hour, min, sec, nsec, true
+}
+
+func gpsDateTime(year, month, day int, timeField string) (time.Time, bool) {
+	hour, min, sec, nsec, ok := parseTimeOfDay(timeField)
+	if !ok || month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, min, sec, nsec, time.UTC)
+	return t.Round(time.Millisecond), true
+}
@@ -0,0 +1,91 @@
+package nmealogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGPSTime(t *testing.T) {
+	tests := []struct {
+		sentence string
+		expected time.Time
+		ok       bool
+	}{
+		{
+			"$GPRMC,130949,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*1F",
+			time.Date(2024, 7, 15, 13, 9, 49, 0, time.UTC),
+			true,
+		},
+		{
+			"$GPRMC,235959.50,A,5930.970,N,02446.315,E,05.7,160,311299,00,E,A*1F",
+			time.Date(1999, 12, 31, 23, 59, 59, 500000000, time.UTC),
+			true,
+		},
+		{
+			"$GPZDA,201530.00,04,07,2002,00,00*60",
+			time.Date(2002, 7, 4, 20, 15, 30, 0, time.UTC),
+			true,
+		},
+		{"$GPRMC,130949,V,,,,,,,150724,,,N*1F", time.Time{}, false},
+		{"$GPRMC,130949,A,5930.970,N,02446.315,E,05.7,160,,00,E,A*1F", time.Time{}, false},
+		{"$GPRMC,1309,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*1F", time.Time{}, false},
+		{"$GPZDA,,,,,,*48", time.Time{}, false},
+		{"$GPGGA,130949,5930.970,N,02446.315,E,1,08,0.9,10.0,M,18.0,M,,*4F", time.Time{}, false},
+		{"garbage", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		gpsTime, ok := ParseGPSTime(test.sentence)
+		if ok != test.ok || !gpsTime.Equal(test.expected) {
+			t.Errorf("ParseGPSTime(%q) = %v, %v; expected %v, %v", test.sentence, gpsTime, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestShiftGPSTime(t *testing.T) {
+	logTime := time.Date(2024, 7, 15, 23, 59, 59, 0, time.UTC)
+	// From the log time to 2026-10-19 00:00:01
+	offset := time.Date(2026, 10, 19, 0, 0, 1, 0, time.UTC).Sub(logTime)
+
+	tests := []struct {
+		sentence string
+		expected string
+		ok       bool
+	}{
+		{
+			"$GPRMC,235959,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A",
+			"$GPRMC,000001,A,5930.970,N,02446.315,E,05.7,160,191026,00,E,A",
+			true,
+		},
+		{
+			"$GPZDA,235958.50,15,07,2024,00,00*6B",
+			"$GPZDA,000000.50,19,10,2026,00,00",
+			true,
+		},
+		// The time of day is past midnight of the log date
+		{
+			"$GPGGA,000000.00,5930.970,N,02446.315,E,1,08,0.9,10.0,M,18.0,M,,*4F",
+			"$GPGGA,000002.00,5930.970,N,02446.315,E,1,08,0.9,10.0,M,18.0,M,,",
+			true,
+		},
+		{
+			"$GPGLL,5930.970,N,02446.315,E,235959.9,A,A*4F",
+			"$GPGLL,5930.970,N,02446.315,E,000001.9,A,A",
+			true,
+		},
+		{"$GPRMC,,V,,,,,,,,,,N*53", "$GPRMC,,V,,,,,,,,,,N*53", false},
+		{"$IIMWV,127,R,21.8,N,A*1C", "$IIMWV,127,R,21.8,N,A*1C", false},
+	}
+
+	for _, test := range tests {
+		shifted, ok := ShiftGPSTime(test.sentence, logTime, offset)
+		expected := test.expected
+		if test.ok {
+			data := expected[1:]
+			expected += "*" + CalculateChecksum(data)
+		}
+		if shifted != expected || ok != test.ok {
+			t.Errorf("ShiftGPSTime(%q) = %q, %v; expected %q, %v", test.sentence, shifted, ok, expected, test.ok)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package nmealogger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// LogTimeFormat is the format of the timestamp at the start of each log line.
+	LogTimeFormat = "2006-01-02T15:04:05.999-0700"
+	// LogFileTimeFormat is the format of the timestamp in log file names.
+	LogFileTimeFormat = "2006-01-02T150405"
+	// LogCommentPrefix starts log lines that are annotations rather than
+	// sentences, eg. notes about clock adjustments.
+	LogCommentPrefix = "#"
+)
+
+// Older logs have the time zone written as "+00:00"
+var logTimeFormats = []string{LogTimeFormat, "2006-01-02T15:04:05.999-07:00"}
+
+// LogEntry is a single line of a log file.
+type LogEntry struct {
+	Time     time.Time
+	Sentence string
+}
+
+// JSONLogEntry is a log entry in the JSON Lines log format. The sentence is
+// kept as is, the decoded fields are included for the supported sentences.
+type JSONLogEntry struct {
+	Time     time.Time      `json:"time"`
+	Source   string         `json:"source,omitempty"`
+	Sentence string         `json:"sentence"`
+	Talker   string         `json:"talker,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
+}
+
+// NewJSONLogEntry returns the JSON log entry for a sentence received at time
+// t from source. Annotations are logged with just the time and the comment.
+func NewJSONLogEntry(t time.Time, source, sentence string) JSONLogEntry {
+	entry := JSONLogEntry{
+		Time:     t.UTC().Truncate(time.Millisecond),
+		Source:   source,
+		Sentence: sentence,
+	}
+	if IsComment(sentence) {
+		entry.Source = ""
+		return entry
+	}
+
+	entry.Talker, entry.Type, _ = SentenceID(sentence)
+	entry.Fields, _ = DecodeSentence(sentence)
+	return entry
+}
+
+// FormatLogEntry formats a log line without the trailing newline, eg.
+// "2024-07-15T13:09:49.217+0000\t$IIVLW,09452,N,030.8,N*52".
+func FormatLogEntry(t time.Time, sentence string) string {
+	return t.UTC().Format(LogTimeFormat) + "\t" + sentence
+}
+
+// ParseLogEntry splits a log line into the timestamp and the sentence.
+func ParseLogEntry(line string) (time.Time, string, error) {
+	timestamp, sentence, ok := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("no tab separator in log line: %q", line)
+	}
+
+	var err error
+	for _, format := range logTimeFormats {
+		var t time.Time
+		if t, err = time.Parse(format, timestamp); err == nil {
+			return t, sentence, nil
+		}
+	}
+
+	return time.Time{}, "", fmt.Errorf("error parsing log timestamp: %w", err)
+}
+
+// IsComment reports whether the logged sentence is an annotation.
+func IsComment(sentence string) bool {
+	return strings.HasPrefix(sentence, LogCommentPrefix)
+}
@@ -0,0 +1,40 @@
+package nmealogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogEntryRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 7, 15, 13, 9, 49, 217000000, time.UTC)
+	sentence := "$IIVLW,09452,N,030.8,N*52"
+
+	line := FormatLogEntry(ts, sentence)
+	if line != "2024-07-15T13:09:49.217+0000\t$IIVLW,09452,N,030.8,N*52" {
+		t.Fatalf("Unexpected log line %q", line)
+	}
+
+	parsedTime, parsedSentence, err := ParseLogEntry(line + "\n")
+	if err != nil {
+		t.Fatalf("Error parsing log line: %v", err)
+	}
+	if !parsedTime.Equal(ts) || parsedSentence != sentence {
+		t.Fatalf("Expected %v %q, got %v %q", ts, sentence, parsedTime, parsedSentence)
+	}
+}
+
+func TestParseLogEntry(t *testing.T) {
+	ts, sentence, err := ParseLogEntry("2023-09-02T10:00:01.5+00:00\t$GPGLL,5930.970,N,02446.315,E,130949,A,A*43")
+	if err != nil {
+		t.Fatalf("Error parsing old format log line: %v", err)
+	}
+	if !ts.Equal(time.Date(2023, 9, 2, 10, 0, 1, 500000000, time.UTC)) || sentence != "$GPGLL,5930.970,N,02446.315,E,130949,A,A*43" {
+		t.Fatalf("Unexpected result %v %q", ts, sentence)
+	}
+
+	for _, line := range []string{"", "$GPGLL,5930.970,N", "yesterday\t$GPGLL,5930.970,N"} {
+		if _, _, err := ParseLogEntry(line); err == nil {
+			t.Errorf("Expected error parsing %q", line)
+		}
+	}
+}
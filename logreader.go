@@ -0,0 +1,375 @@
+package nmealogger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExpandLogFiles turns a list of log files, directories and glob patterns
+// into the list of log files ordered by the time of their first entry.
+// Directories are searched for .log files, but not recursively. Files
+// without any log entries are left out.
+func ExpandLogFiles(inputs []string) ([]string, error) {
+	var candidates []string
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		switch {
+		case err == nil && info.IsDir():
+			matches, err := filepath.Glob(filepath.Join(input, "*.log"))
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, matches...)
+		case err == nil:
+			candidates = append(candidates, input)
+		default:
+			matches, globErr := filepath.Glob(input)
+			if globErr != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", input, globErr)
+			}
+			if len(matches) == 0 {
+				return nil, err
+			}
+			candidates = append(candidates, matches...)
+		}
+	}
+
+	type logFile struct {
+		name  string
+		start time.Time
+	}
+	var files []logFile
+	seen := make(map[string]bool)
+	for _, name := range candidates {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		start, err := firstEntryTime(name)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, logFile{name, start})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].start.Before(files[j].start) })
+
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.name
+	}
+	return names, nil
+}
+
+// firstEntryTime returns the time of the first entry in the log file, or
+// io.EOF if it has none.
+func firstEntryTime(fileName string) (time.Time, error) {
+	reader := NewLogReader([]string{fileName})
+	defer reader.Close()
+
+	entry, err := reader.Next()
+	return entry.Time, err
+}
+
+// The log index records the file offset of an entry at most this often in
+// log time, seeking reads forward from the closest indexed entry
+const LogIndexInterval = 10 * time.Second
+
+// LogReader reads the entries of a sequence of log files one at a time, so
+// that the files don't need to fit in memory. Lines that can't be parsed are
+// skipped. The CSV logs of signalk-logger are read as the NMEA sentences
+// synthesized from each row by SignalKToNMEA.
+type LogReader struct {
+	files []string
+	// Index in files of the file being read
+	current int
+	file    *os.File
+	scanner *bufio.Scanner
+	// The header columns if the file being read is a SignalK CSV log
+	signalKColumns []string
+	// Entries synthesized from the SignalK row that was read last
+	queue []LogEntry
+	// Offset of the next line in the file being read and of the line of the
+	// last entry
+	offset      int64
+	entryOffset int64
+	// Entry read ahead by Seek, returned by the next call to Next
+	pending *LogEntry
+	// Time of the first entry of each file and the entry offsets of the files
+	// that have been sought into, built on demand
+	starts  map[string]time.Time
+	indexes map[string][]logOffset
+}
+
+// logOffset is the offset of an entry in a log file.
+type logOffset struct {
+	time   time.Time
+	offset int64
+}
+
+func NewLogReader(files []string) *LogReader {
+	return &LogReader{
+		files:   files,
+		starts:  make(map[string]time.Time),
+		indexes: make(map[string][]logOffset),
+	}
+}
+
+// Next returns the next entry, or io.EOF after the last file.
+func (r *LogReader) Next() (LogEntry, error) {
+	if r.pending != nil {
+		entry := *r.pending
+		r.pending = nil
+		return entry, nil
+	}
+	if len(r.queue) > 0 {
+		entry := r.queue[0]
+		r.queue = r.queue[1:]
+		return entry, nil
+	}
+
+	for {
+		if r.scanner == nil {
+			if r.current >= len(r.files) {
+				return LogEntry{}, io.EOF
+			}
+			if err := r.open(r.files[r.current], 0); err != nil {
+				return LogEntry{}, err
+			}
+		}
+
+		lineOffset := r.offset
+		if !r.scanner.Scan() {
+			err := r.scanner.Err()
+			r.closeFile()
+			if err != nil {
+				return LogEntry{}, fmt.Errorf("error reading %s: %w", r.files[r.current], err)
+			}
+			r.current++
+			continue
+		}
+
+		if r.signalKColumns != nil {
+			// The header doesn't parse as a record
+			record, err := ParseSignalKRecord(r.signalKColumns, r.scanner.Text())
+			if err != nil {
+				continue
+			}
+			for _, sentence := range SignalKToNMEA(record) {
+				r.queue = append(r.queue, LogEntry{Time: record.Time, Sentence: sentence})
+			}
+			if len(r.queue) == 0 {
+				continue
+			}
+			r.entryOffset = lineOffset
+			entry := r.queue[0]
+			r.queue = r.queue[1:]
+			return entry, nil
+		}
+
+		t, sentence, err := ParseLogEntry(r.scanner.Text())
+		if err != nil {
+			continue
+		}
+		r.entryOffset = lineOffset
+		return LogEntry{Time: t, Sentence: sentence}, nil
+	}
+}
+
+// Position returns the index in the files of the file being read and the
+// offset of the next line in it. The index is the number of files after the
+// last one.
+func (r *LogReader) Position() (int, int64) {
+	if r.pending != nil || len(r.queue) > 0 {
+		// The entry was read ahead, but it hasn't been returned yet
+		return r.current, r.entryOffset
+	}
+	return r.current, r.offset
+}
+
+// Seek positions the reader at the first entry logged at or after t, or at
+// the end if there is none. The files are expected to be in time order, as
+// returned by ExpandLogFiles. The offsets of the entries in a file are indexed
+// the first time it is sought into, so that later seeks don't need to read
+// through the file.
+func (r *LogReader) Seek(t time.Time) error {
+	r.closeFile()
+	r.pending = nil
+	r.queue = nil
+
+	// The last file that starts before t, or the first one
+	r.current = 0
+	for i, fileName := range r.files {
+		start, err := r.startTime(fileName)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if start.After(t) {
+			break
+		}
+		r.current = i
+	}
+	if len(r.files) == 0 {
+		return nil
+	}
+
+	fileName := r.files[r.current]
+	index, err := r.index(fileName)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if i := sort.Search(len(index), func(i int) bool { return index[i].time.After(t) }); i > 0 {
+		offset = index[i-1].offset
+	}
+	if err := r.open(fileName, offset); err != nil {
+		return err
+	}
+
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.Time.Before(t) {
+			r.pending = &entry
+			return nil
+		}
+	}
+}
+
+func (r *LogReader) startTime(fileName string) (time.Time, error) {
+	if start, ok := r.starts[fileName]; ok {
+		return start, nil
+	}
+	start, err := firstEntryTime(fileName)
+	if err == nil {
+		r.starts[fileName] = start
+	}
+	return start, err
+}
+
+// index returns the offsets of the entries at most LogIndexInterval apart,
+// reading through the file if it hasn't been indexed yet. Entries that go
+// back in time, eg. after a clock step, are not indexed so that the index
+// stays sorted.
+func (r *LogReader) index(fileName string) ([]logOffset, error) {
+	if index, ok := r.indexes[fileName]; ok {
+		return index, nil
+	}
+
+	file, signalKColumns, err := openLogFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var index []logOffset
+	var offset int64
+	scanner := newLineScanner(file, &offset)
+	for {
+		lineOffset := offset
+		if !scanner.Scan() {
+			break
+		}
+		var t time.Time
+		if signalKColumns != nil {
+			// Only the time is needed, it's the first column
+			timestamp, _, _ := strings.Cut(scanner.Text(), ",")
+			t, err = time.Parse(time.RFC3339, timestamp)
+		} else {
+			t, _, err = ParseLogEntry(scanner.Text())
+		}
+		if err != nil {
+			continue
+		}
+		if len(index) == 0 || t.Sub(index[len(index)-1].time) >= LogIndexInterval {
+			index = append(index, logOffset{t, lineOffset})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error indexing %s: %w", fileName, err)
+	}
+
+	r.indexes[fileName] = index
+	return index, nil
+}
+
+func (r *LogReader) open(fileName string, offset int64) error {
+	file, signalKColumns, err := openLogFile(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.signalKColumns = signalKColumns
+	r.offset = offset
+	r.scanner = newLineScanner(file, &r.offset)
+	return nil
+}
+
+// openLogFile opens the log file and returns the header columns if it's a
+// SignalK CSV log. The file is left at an unspecified offset.
+func openLogFile(fileName string) (*os.File, []string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, nil, fmt.Errorf("error reading %s: %w", fileName, err)
+	}
+	columns, _ := ParseSignalKHeader(strings.TrimRight(header, "\r\n"))
+	return file, columns, nil
+}
+
+// newLineScanner returns a scanner for the lines of the file that keeps
+// offset at the start of the next line.
+func newLineScanner(file *os.File, offset *int64) *bufio.Scanner {
+	scanner := bufio.NewScanner(file)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		*offset += int64(advance)
+		return advance, token, err
+	})
+	return scanner
+}
+
+func (r *LogReader) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+	}
+	r.file = nil
+	r.scanner = nil
+	r.signalKColumns = nil
+}
+
+// Close closes the file being read.
+func (r *LogReader) Close() {
+	r.closeFile()
+	r.pending = nil
+	r.queue = nil
+	r.current = len(r.files)
+}
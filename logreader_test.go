@@ -0,0 +1,191 @@
+package nmealogger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeLogFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Error writing log file: %v", err)
+	}
+	return path
+}
+
+func TestLogReader(t *testing.T) {
+	dir := t.TempDir()
+	// The file names don't sort in time order, the entries do
+	second := writeLogFile(t, dir, "b.log",
+		"2024-07-15T13:10:00.000+0000\t$IIVLW,09452,N,030.8,N*52\n"+
+			"2024-07-15T13:10:01.000+0000\t$IIMWV,127,R,21.8,N,A*1C\n")
+	first := writeLogFile(t, dir, "c.log",
+		"garbage\n"+
+			"2024-07-15T13:05:00.000+00:00\t$IIVLW,09450,N,030.6,N*52\n"+
+			"\n"+
+			"2024-07-15T13:05:01.000+0000\t# GPS clock synced\n")
+	writeLogFile(t, dir, "empty.log", "")
+	writeLogFile(t, dir, "notes.txt", "2024-07-15T13:00:00.000+0000\tnot a log\n")
+
+	files, err := ExpandLogFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("Error expanding log files: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{first, second}) {
+		t.Fatalf("Expected files in time order, got %v", files)
+	}
+
+	globbed, err := ExpandLogFiles([]string{filepath.Join(dir, "*.log"), second})
+	if err != nil {
+		t.Fatalf("Error expanding glob: %v", err)
+	}
+	if !reflect.DeepEqual(globbed, files) {
+		t.Errorf("Expected the glob to give %v, got %v", files, globbed)
+	}
+
+	if _, err := ExpandLogFiles([]string{filepath.Join(dir, "missing.log")}); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+
+	reader := NewLogReader(files)
+	defer reader.Close()
+
+	var sentences []string
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading log: %v", err)
+		}
+		sentences = append(sentences, entry.Time.Format("15:04:05")+" "+entry.Sentence)
+	}
+
+	expected := []string{
+		"13:05:00 $IIVLW,09450,N,030.6,N*52",
+		"13:05:01 # GPS clock synced",
+		"13:10:00 $IIVLW,09452,N,030.8,N*52",
+		"13:10:01 $IIMWV,127,R,21.8,N,A*1C",
+	}
+	if !reflect.DeepEqual(sentences, expected) {
+		t.Errorf("Expected entries %v, got %v", expected, sentences)
+	}
+}
+
+func TestLogReaderSeek(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	var files []string
+	for i, name := range []string{"a.log", "b.log"} {
+		var lines strings.Builder
+		// A minute of entries a second apart, the second file starts an hour later
+		for j := 0; j < 60; j++ {
+			entryTime := start.Add(time.Duration(i)*time.Hour + time.Duration(j)*time.Second)
+			fmt.Fprintf(&lines, "%s\t$IIVLW,%05d,N,030.6,N*52\r\n", entryTime.Format(LogTimeFormat), j)
+		}
+		files = append(files, writeLogFile(t, dir, name, lines.String()))
+	}
+
+	reader := NewLogReader(files)
+	defer reader.Close()
+
+	tests := []struct {
+		seek      time.Time
+		expected  string
+		fileIndex int
+	}{
+		{start.Add(35 * time.Second), "13:00:35", 0},
+		{start.Add(12*time.Second + 500*time.Millisecond), "13:00:13", 0},
+		{start.Add(-time.Hour), "13:00:00", 0},
+		// Between the files
+		{start.Add(30 * time.Minute), "14:00:00", 1},
+		{start.Add(time.Hour + 59*time.Second), "14:00:59", 1},
+		{start.Add(5 * time.Second), "13:00:05", 0},
+	}
+	for _, test := range tests {
+		if err := reader.Seek(test.seek); err != nil {
+			t.Fatalf("Error seeking to %v: %v", test.seek, err)
+		}
+
+		fileIndex, offset := reader.Position()
+		entry, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Error reading after seeking to %v: %v", test.seek, err)
+		}
+		if got := entry.Time.Format("15:04:05"); got != test.expected {
+			t.Errorf("Seek to %v: expected entry at %s, got %s", test.seek, test.expected, got)
+		}
+		if fileIndex != test.fileIndex {
+			t.Errorf("Seek to %v: expected file %d, got %d", test.seek, test.fileIndex, fileIndex)
+		}
+
+		// The position is at the line of the entry
+		contents, _ := os.ReadFile(files[fileIndex])
+		line, _, _ := strings.Cut(string(contents[offset:]), "\r\n")
+		if _, sentence, _ := ParseLogEntry(line); sentence != entry.Sentence {
+			t.Errorf("Seek to %v: expected the position at %q, got %q", test.seek, entry.Sentence, line)
+		}
+	}
+
+	if err := reader.Seek(start.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Error seeking past the end: %v", err)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Expected EOF after seeking past the end, got %v", err)
+	}
+}
+
+func TestLogReaderSignalK(t *testing.T) {
+	dir := t.TempDir()
+	signalK := writeLogFile(t, dir, "signalk-2024-07-15T130000.log",
+		"time,environment.depth.belowTransducer,environment.water.temperature\n"+
+			"2024-07-15T13:00:00Z,12.300000,288.150000\n"+
+			"2024-07-15T13:00:01Z,,\n"+
+			"2024-07-15T13:00:12Z,12.500000,\n")
+
+	files, err := ExpandLogFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("Error expanding log files: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{signalK}) {
+		t.Fatalf("Expected the SignalK log, got %v", files)
+	}
+
+	reader := NewLogReader(files)
+	defer reader.Close()
+
+	var sentences []string
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading log: %v", err)
+		}
+		sentences = append(sentences, entry.Time.Format("15:04:05")+" "+entry.Sentence)
+	}
+
+	expected := []string{
+		"13:00:00 $IIDPT,12.3,0.0*70",
+		"13:00:00 $IIMTW,15.0,C*17",
+		"13:00:12 $IIDPT,12.5,0.0*76",
+	}
+	if !reflect.DeepEqual(sentences, expected) {
+		t.Errorf("Expected entries %v, got %v", expected, sentences)
+	}
+
+	if err := reader.Seek(time.Date(2024, 7, 15, 13, 0, 5, 0, time.UTC)); err != nil {
+		t.Fatalf("Error seeking: %v", err)
+	}
+	if entry, err := reader.Next(); err != nil || entry.Sentence != "$IIDPT,12.5,0.0*76" {
+		t.Errorf("Expected the last depth after seeking, got %v (%v)", entry, err)
+	}
+}
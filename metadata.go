@@ -0,0 +1,159 @@
+package nmealogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// MetadataSuffix is the extension of the metadata file written next to each
+// log file, eg. nmea-2024-07-15T130949.meta.json.
+const MetadataSuffix = ".meta.json"
+
+// Version of the software, set at build time with
+// -ldflags "-X github.com/mpihlak/go-nmealogger.Version=1.0-6".
+var Version = "dev"
+
+const earthRadiusNM = 3440.065
+
+// LogMetadata summarizes a log file so that the files can be found without
+// reading them through.
+type LogMetadata struct {
+	File  string    `json:"file"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Number of sentences by talker and type, eg. "GPRMC"
+	Sentences map[string]int `json:"sentences"`
+	Rejects   int            `json:"rejects"`
+	// Bounding box of the positions, nil if there were no valid positions
+	BoundingBox *BoundingBox `json:"boundingBox,omitempty"`
+	// Distance sailed in nautical miles
+	Distance float64 `json:"distance"`
+	Version  string  `json:"version"`
+
+	// Distance is measured from the first position source so that positions
+	// from several GPS receivers don't zigzag
+	positionSource string
+	lastLatitude   float64
+	lastLongitude  float64
+}
+
+type BoundingBox struct {
+	MinLatitude  float64 `json:"minLatitude"`
+	MinLongitude float64 `json:"minLongitude"`
+	MaxLatitude  float64 `json:"maxLatitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+}
+
+func NewLogMetadata(file string) *LogMetadata {
+	return &LogMetadata{
+		File:      file,
+		Sentences: make(map[string]int),
+		Version:   Version,
+	}
+}
+
+// AddSentence adds a sentence logged at time t. Annotations only extend the
+// time range.
+func (m *LogMetadata) AddSentence(t time.Time, sentence string) {
+	m.addTime(t)
+	if IsComment(sentence) {
+		return
+	}
+
+	talker, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return
+	}
+	source := talker + sentenceType
+	m.Sentences[source]++
+
+	latitude, longitude, ok := ParsePosition(sentence)
+	if !ok {
+		return
+	}
+
+	if m.BoundingBox == nil {
+		m.BoundingBox = &BoundingBox{latitude, longitude, latitude, longitude}
+	} else {
+		m.BoundingBox.MinLatitude = math.Min(m.BoundingBox.MinLatitude, latitude)
+		m.BoundingBox.MinLongitude = math.Min(m.BoundingBox.MinLongitude, longitude)
+		m.BoundingBox.MaxLatitude = math.Max(m.BoundingBox.MaxLatitude, latitude)
+		m.BoundingBox.MaxLongitude = math.Max(m.BoundingBox.MaxLongitude, longitude)
+	}
+
+	if m.positionSource == "" {
+		m.positionSource = source
+	} else if source == m.positionSource {
+		m.Distance += DistanceNM(m.lastLatitude, m.lastLongitude, latitude, longitude)
+	} else {
+		return
+	}
+	m.lastLatitude, m.lastLongitude = latitude, longitude
+}
+
+// AddReject counts a sentence that was rejected at time t.
+func (m *LogMetadata) AddReject(t time.Time) {
+	m.addTime(t)
+	m.Rejects++
+}
+
+func (m *LogMetadata) addTime(t time.Time) {
+	t = t.Truncate(time.Millisecond)
+	if m.Start.IsZero() || t.Before(m.Start) {
+		m.Start = t.UTC()
+	}
+	if t.After(m.End) {
+		m.End = t.UTC()
+	}
+}
+
+// Write writes the metadata as JSON to the file.
+func (m *LogMetadata) Write(fileName string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(fileName, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing metadata: %w", err)
+	}
+	return nil
+}
+
+// ParseLogMetadata parses the contents of a metadata file.
+func ParseLogMetadata(data []byte) (*LogMetadata, error) {
+	var m LogMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing metadata: %w", err)
+	}
+	return &m, nil
+}
+
+// LogBaseName returns the name of a log related file without the extensions,
+// so that nmea-2024-07-15T130949.log.uploaded and
+// nmea-2024-07-15T130949.meta.json both give nmea-2024-07-15T130949.
+func LogBaseName(fileName string) string {
+	fileName = strings.TrimSuffix(fileName, ".uploaded")
+	for _, suffix := range []string{MetadataSuffix, ".log", ".jsonl", ".rejects", ".events"} {
+		if strings.HasSuffix(fileName, suffix) {
+			return strings.TrimSuffix(fileName, suffix)
+		}
+	}
+	return fileName
+}
+
+// DistanceNM returns the great circle distance between two positions in
+// nautical miles.
+func DistanceNM(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusNM * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,71 @@
+package nmealogger
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogMetadata(t *testing.T) {
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	m := NewLogMetadata("nmea-2024-07-15T130000.log")
+
+	m.AddSentence(start, "$GPRMC,130000,A,5930.000,N,02440.000,E,05.7,160,150724,00,E,A*1F")
+	m.AddSentence(start.Add(time.Second), "$IIVLW,09452,N,030.8,N*52")
+	m.AddSentence(start.Add(2*time.Second), "$GPGLL,5935.000,N,02450.000,E,130002,A,A*43")
+	m.AddSentence(start.Add(3*time.Second), "$GPRMC,130003,V,5940.000,N,02450.000,E,05.7,160,150724,00,E,A*1F")
+	m.AddSentence(start.Add(4*time.Second), "$GPRMC,130004,A,5931.000,N,02440.000,E,05.7,160,150724,00,E,A*1F")
+	m.AddSentence(start.Add(5*time.Second), "# GPS clock synced")
+	m.AddReject(start.Add(6 * time.Second))
+
+	if !m.Start.Equal(start) || !m.End.Equal(start.Add(6*time.Second)) {
+		t.Errorf("Unexpected time range %v - %v", m.Start, m.End)
+	}
+	if m.Sentences["GPRMC"] != 3 || m.Sentences["IIVLW"] != 1 || m.Sentences["GPGLL"] != 1 || len(m.Sentences) != 3 {
+		t.Errorf("Unexpected sentence counts %v", m.Sentences)
+	}
+	if m.Rejects != 1 {
+		t.Errorf("Expected 1 reject, got %d", m.Rejects)
+	}
+
+	expectedBox := BoundingBox{59.5, 24 + 40.0/60, 59 + 35.0/60, 24 + 50.0/60}
+	if m.BoundingBox == nil || *m.BoundingBox != expectedBox {
+		t.Errorf("Expected bounding box %+v, got %+v", expectedBox, m.BoundingBox)
+	}
+	// One minute of latitude along the RMC positions, GLL and void RMC are ignored
+	if math.Abs(m.Distance-1) > 0.01 {
+		t.Errorf("Expected distance of 1nm, got %v", m.Distance)
+	}
+
+	path := filepath.Join(t.TempDir(), "nmea-2024-07-15T130000"+MetadataSuffix)
+	if err := m.Write(path); err != nil {
+		t.Fatalf("Error writing metadata: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %v", err)
+	}
+	parsed, err := ParseLogMetadata(data)
+	if err != nil {
+		t.Fatalf("Error parsing metadata: %v", err)
+	}
+	if parsed.File != m.File || !parsed.End.Equal(m.End) || parsed.Distance != m.Distance || parsed.Version != Version {
+		t.Errorf("Metadata changed in a round trip: %+v", parsed)
+	}
+}
+
+func TestLogBaseName(t *testing.T) {
+	for _, name := range []string{
+		"nmea-2024-07-15T130949.log",
+		"nmea-2024-07-15T130949.log.uploaded",
+		"nmea-2024-07-15T130949.jsonl",
+		"nmea-2024-07-15T130949.meta.json",
+		"nmea-2024-07-15T130949.rejects",
+	} {
+		if base := LogBaseName(name); base != "nmea-2024-07-15T130949" {
+			t.Errorf("Expected base name of %s to be nmea-2024-07-15T130949, got %s", name, base)
+		}
+	}
+}
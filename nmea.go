@@ -21,6 +21,49 @@ func HasValidChecksum(sentence string) bool {
 	return CalculateChecksum(data[1:]) == providedChecksum
 }
 
+// SentenceError describes why a sentence is not valid.
+type SentenceError struct {
+	// Reason is a short identifier for the error, suitable for metric labels
+	Reason      string
+	Description string
+}
+
+func (e *SentenceError) Error() string {
+	return e.Description
+}
+
+var (
+	ErrNoStartDelimiter  = &SentenceError{"no_start_delimiter", "sentence doesn't start with $"}
+	ErrInvalidCharacters = &SentenceError{"invalid_characters", "sentence contains non-printable characters"}
+	ErrNoChecksum        = &SentenceError{"no_checksum", "sentence has no checksum"}
+	ErrBadChecksum       = &SentenceError{"bad_checksum", "sentence has an invalid checksum"}
+)
+
+// ValidateSentence checks that the sentence is well formed and has a valid
+// checksum. Returns one of the SentenceError values if not. Unlike
+// HasValidChecksum it also rejects sentences with non-printable characters.
+func ValidateSentence(sentence string) error {
+	if !strings.HasPrefix(sentence, "$") {
+		return ErrNoStartDelimiter
+	}
+
+	for _, c := range sentence {
+		if c < 0x20 || c > 0x7e {
+			return ErrInvalidCharacters
+		}
+	}
+
+	data, providedChecksum, ok := strings.Cut(sentence, "*")
+	if !ok {
+		return ErrNoChecksum
+	}
+	if CalculateChecksum(data[1:]) != providedChecksum {
+		return ErrBadChecksum
+	}
+
+	return nil
+}
+
 // CalculateChecksum calculates the XOR checksum for an NMEA sentence. It assumes
 // that the checksum part and leading $ are already stripped from the sentence.
 func CalculateChecksum(strippedSentence string) string {
@@ -31,3 +74,132 @@ func CalculateChecksum(strippedSentence string) string {
 
 	return fmt.Sprintf("%02X", checksum)
 }
+
+// SentenceID returns the talker and sentence type of an NMEA sentence. For
+// "$IIMWV,129,R,22.5,N,A*1C" these are "II" and "MWV". Proprietary sentences
+// such as "$PGRME,..." have talker "P" and the rest of the address ("GRME")
+// as the sentence type.
+func SentenceID(sentence string) (talker, sentenceType string, ok bool) {
+	if !strings.HasPrefix(sentence, "$") && !strings.HasPrefix(sentence, "!") {
+		return "", "", false
+	}
+
+	address, _, _ := strings.Cut(sentence[1:], ",")
+	address, _, _ = strings.Cut(address, "*")
+	if strings.HasPrefix(address, "P") && len(address) > 1 {
+		return "P", address[1:], true
+	}
+	if len(address) < 3 {
+		return "", "", false
+	}
+
+	return address[:2], address[2:], true
+}
+
+// ParseSentenceFilter parses a comma separated list of sentence patterns as
+// used by MatchSentence, eg. "RMC,IIMWV,GP".
+func ParseSentenceFilter(filter string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(filter, ",") {
+		pattern = strings.ToUpper(strings.TrimSpace(pattern))
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// MatchSentence reports whether the sentence matches any of the patterns. A
+// pattern is either a talker ("GP"), a sentence type ("RMC") or both ("GPRMC").
+func MatchSentence(patterns []string, sentence string) bool {
+	talker, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if pattern == talker || pattern == sentenceType || pattern == talker+sentenceType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SentenceRename renames the talker ("II=GP"), the sentence type
+// ("VWR=MWV") or both ("IIHDG=HCHDG") of the matching sentences.
+type SentenceRename struct {
+	From string
+	To   string
+}
+
+// ParseSentenceRenames parses a comma separated list of from=to renames as
+// used by RenameSentence, eg. "II=GP,VWR=MWV". Both sides must be talkers,
+// sentence types or addresses of two, three and five characters.
+func ParseSentenceRenames(spec string) ([]SentenceRename, error) {
+	var renames []SentenceRename
+	for _, rename := range strings.Split(spec, ",") {
+		rename = strings.ToUpper(strings.TrimSpace(rename))
+		if rename == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(rename, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || len(from) != len(to) || (len(from) != 2 && len(from) != 3 && len(from) != 5) {
+			return nil, fmt.Errorf("invalid rename %q, expected talker=talker, type=type or address=address", rename)
+		}
+		renames = append(renames, SentenceRename{From: from, To: to})
+	}
+
+	return renames, nil
+}
+
+// RenameSentence applies the renames in order and recomputes the checksum if
+// the sentence was renamed. Sentences without a checksum don't get one.
+func RenameSentence(renames []SentenceRename, sentence string) string {
+	talker, sentenceType, ok := SentenceID(sentence)
+	if !ok || talker == "P" {
+		return sentence
+	}
+
+	address := talker + sentenceType
+	renamed := address
+	for _, rename := range renames {
+		switch len(rename.From) {
+		case 2:
+			if renamed[:2] == rename.From {
+				renamed = rename.To + renamed[2:]
+			}
+		case 3:
+			if renamed[2:] == rename.From {
+				renamed = renamed[:2] + rename.To
+			}
+		default:
+			if renamed == rename.From {
+				renamed = rename.To
+			}
+		}
+	}
+	if renamed == address {
+		return sentence
+	}
+
+	data, _, hasChecksum := strings.Cut(renamed+sentence[1+len(address):], "*")
+	if !hasChecksum {
+		return sentence[:1] + data
+	}
+	return sentence[:1] + data + "*" + CalculateChecksum(data)
+}
+
+// SentenceFields splits the sentence into comma separated fields, with the
+// leading $ and the checksum removed. The first field is the address, eg.
+// "IIMWV" for "$IIMWV,129,R,22.5,N,A*1C".
+func SentenceFields(sentence string) []string {
+	if len(sentence) > 0 && (sentence[0] == '$' || sentence[0] == '!') {
+		sentence = sentence[1:]
+	}
+	data, _, _ := strings.Cut(sentence, "*")
+
+	return strings.Split(data, ",")
+}
@@ -1,6 +1,9 @@
 package nmealogger
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestCalculateChecksum(t *testing.T) {
 	cksum := CalculateChecksum("IIVLW,09390,N,000.0,N")
@@ -28,3 +31,120 @@ func TestHasValidChecksum(t *testing.T) {
 		t.Fatal("Expected checksum to be valid for sentence")
 	}
 }
+
+func TestSentenceID(t *testing.T) {
+	tests := []struct {
+		sentence     string
+		talker       string
+		sentenceType string
+		ok           bool
+	}{
+		{"$IIMWV,127,R,21.8,N,A*1C", "II", "MWV", true},
+		{"!AIVDM,1,1,,A,13aEOK?P00PD2wVMdLDRhgvL289?,0*26", "AI", "VDM", true},
+		{"$PGRME,15.0,M,45.0,M,25.0,M*1C", "P", "GRME", true},
+		{"$GPZDA*41", "GP", "ZDA", true},
+		{"IIMWV,127,R,21.8,N,A*1C", "", "", false},
+		{"$II,1*00", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, test := range tests {
+		talker, sentenceType, ok := SentenceID(test.sentence)
+		if talker != test.talker || sentenceType != test.sentenceType || ok != test.ok {
+			t.Errorf("SentenceID(%q) = %q, %q, %v; expected %q, %q, %v",
+				test.sentence, talker, sentenceType, ok, test.talker, test.sentenceType, test.ok)
+		}
+	}
+}
+
+func TestMatchSentence(t *testing.T) {
+	patterns := ParseSentenceFilter(" rmc, IIMWV,,GL ")
+	if len(patterns) != 3 {
+		t.Fatalf("Expected 3 patterns, got %v", patterns)
+	}
+
+	tests := []struct {
+		sentence string
+		match    bool
+	}{
+		{"$GPRMC,130949,A,5930.970,N,02446.315,E,05.7,160,150724,00,E,A*1F", true},
+		{"$IIRMC,130900,A,5930.975,N,02446.310,E,05.9,161,150724,00,E,A*0A", true},
+		{"$IIMWV,127,R,21.8,N,A*1C", true},
+		{"$WIMWV,127,R,21.8,N,A*1C", false},
+		{"$GLGSV,3,1,09,65,21,048,,66,59,094,,67,39,160,,72,16,330,*6E", true},
+		{"$IIVHW,,,117,M,05.7,N,,*61", false},
+		{"garbage", false},
+	}
+
+	for _, test := range tests {
+		if MatchSentence(patterns, test.sentence) != test.match {
+			t.Errorf("MatchSentence(%v, %q) expected %v", patterns, test.sentence, test.match)
+		}
+	}
+}
+
+func TestRenameSentence(t *testing.T) {
+	renames, err := ParseSentenceRenames(" ii=GP, VWR=MWV,GPHDG=HCHDT ")
+	if err != nil {
+		t.Fatalf("Error parsing renames: %v", err)
+	}
+
+	tests := []struct {
+		sentence string
+		expected string
+	}{
+		{"$IIMWV,127,R,21.8,N,A*1C", "$GPMWV,127,R,21.8,N,A"},
+		{"$WIVWR,127,R,21.8,N,,,,*7A", "$WIMWV,127,R,21.8,N,,,,"},
+		// Renamed to GPHDG by the first rename
+		{"$IIHDG,101.1,,,7.1,W*3C", "$HCHDT,101.1,,,7.1,W"},
+		{"$GPVHW,,,117,M,05.7,N,,", "$GPVHW,,,117,M,05.7,N,,"},
+		{"$IIVHW,,,117,M,05.7,N,,", "$GPVHW,,,117,M,05.7,N,,"},
+		{"$PGRME,15.0,M,45.0,M,25.0,M*1C", "$PGRME,15.0,M,45.0,M,25.0,M*1C"},
+		{"garbage", "garbage"},
+	}
+
+	for _, test := range tests {
+		expected := test.expected
+		if strings.Contains(test.sentence, "*") && !strings.Contains(expected, "*") {
+			expected += "*" + CalculateChecksum(expected[1:])
+		}
+		if got := RenameSentence(renames, test.sentence); got != expected {
+			t.Errorf("RenameSentence(%q) = %q, expected %q", test.sentence, got, expected)
+		}
+	}
+
+	for _, spec := range []string{"II", "II=GPS", "RMC=GP", "IIMWV=MWV"} {
+		if _, err := ParseSentenceRenames(spec); err == nil {
+			t.Errorf("Expected an error for rename %q", spec)
+		}
+	}
+}
+
+func TestValidateSentence(t *testing.T) {
+	tests := []struct {
+		sentence string
+		err      error
+	}{
+		{"$IIVLW,09390,N,000.0,N*50", nil},
+		{"$IIMWV,127,R,21.8,N,A*1c", ErrBadChecksum},
+		{"!AIVDM,1,1,,A,13aEOK?P00PD2wVMdLDRhgvL289?,0*26", ErrNoStartDelimiter},
+		{"", ErrNoStartDelimiter},
+		{"IIVLW,09390,N,000.0,N*50", ErrNoStartDelimiter},
+		{"$IIVLW,09390,N,\x00\x0500.0,N*50", ErrInvalidCharacters},
+		// Rejected even though the checksum matches
+		{"$IIVLW,09390,N,\x0700.0,N*67", ErrInvalidCharacters},
+		{"$IIVLW,09390,N,000.0,N", ErrNoChecksum},
+		{"$IIVLW,09390,N,000.0,N*51", ErrBadChecksum},
+	}
+
+	for _, test := range tests {
+		err := ValidateSentence(test.sentence)
+		if err != test.err {
+			t.Errorf("ValidateSentence(%q) = %v, expected %v", test.sentence, err, test.err)
+		}
+		// Otherwise the same sentences are accepted as by HasValidChecksum
+		if err != ErrInvalidCharacters && (err == nil) != HasValidChecksum(test.sentence) {
+			t.Errorf("ValidateSentence(%q) = %v, but HasValidChecksum disagrees", test.sentence, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package nmealogger
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SdNotify sends a state notification such as "READY=1" to systemd. Does
+// nothing if the process was not started by systemd with a notify socket.
+func SdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often systemd expects a "WATCHDOG=1"
+// notification, or 0 if the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watchdog pings the systemd watchdog, but only while data is flowing. If
+// Activity is not called within the watchdog interval the pings stop and
+// systemd restarts the service.
+type Watchdog struct {
+	interval     time.Duration
+	lastActivity atomic.Int64
+}
+
+// NewWatchdog returns a watchdog for the interval configured by systemd, or
+// nil if the watchdog is not enabled. All methods are safe to call on nil.
+func NewWatchdog() *Watchdog {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return nil
+	}
+
+	return &Watchdog{interval: interval}
+}
+
+// Activity records that data was received.
+func (w *Watchdog) Activity() {
+	if w == nil {
+		return
+	}
+	w.lastActivity.Store(time.Now().UnixNano())
+}
+
+// Run sends the watchdog pings until the context is cancelled.
+func (w *Watchdog) Run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+
+	log.Printf("Systemd watchdog enabled, interval %v", w.interval)
+	ticker := time.NewTicker(w.interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lastActivity := time.Unix(0, w.lastActivity.Load())
+			if time.Since(lastActivity) > w.interval {
+				continue
+			}
+			if err := SdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("Error notifying systemd watchdog: %v", err)
+			}
+		}
+	}
+}
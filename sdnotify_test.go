@@ -0,0 +1,84 @@
+package nmealogger
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Error listening on notify socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn, timeout time.Duration) (string, bool) {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", false
+	}
+	return string(buf[:n]), true
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := SdNotify("READY=1"); err != nil {
+		t.Fatalf("Expected no error without notify socket, got %v", err)
+	}
+
+	conn := listenNotifySocket(t)
+	if err := SdNotify("READY=1"); err != nil {
+		t.Fatalf("Error sending notification: %v", err)
+	}
+	if state, ok := readNotification(t, conn, time.Second); !ok || state != "READY=1" {
+		t.Fatalf("Expected READY=1, got %q", state)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Fatalf("Expected watchdog to be disabled, got interval %v", interval)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "1")
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Fatalf("Expected watchdog to be disabled for other pid, got interval %v", interval)
+	}
+
+	t.Setenv("WATCHDOG_PID", "")
+	if interval := WatchdogInterval(); interval != 30*time.Second {
+		t.Fatalf("Expected 30s watchdog interval, got %v", interval)
+	}
+}
+
+func TestWatchdogPingsOnlyWithActivity(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "100000")
+
+	watchdog := NewWatchdog()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchdog.Run(ctx)
+
+	if state, ok := readNotification(t, conn, 200*time.Millisecond); ok {
+		t.Fatalf("Expected no pings without activity, got %q", state)
+	}
+
+	watchdog.Activity()
+	if state, ok := readNotification(t, conn, 200*time.Millisecond); !ok || state != "WATCHDOG=1" {
+		t.Fatalf("Expected WATCHDOG=1 after activity, got %q", state)
+	}
+}
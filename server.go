@@ -0,0 +1,278 @@
+package nmealogger
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const clientWriteTimeout = 10 * time.Second
+
+// SentenceServer re-serves NMEA sentences to any number of TCP clients. Each
+// client has its own send buffer and is disconnected if it falls behind by
+// more than the buffer size, so that a slow client doesn't hold up the others.
+// BroadcastWait waits for the slow clients instead.
+//
+// Clients can restrict what they receive by sending a line of the form
+// "FILTER RMC,IIMWV,GP" (see MatchSentence). A bare "FILTER" removes the
+// filter. Anything else sent by the clients is ignored.
+type SentenceServer struct {
+	listener      net.Listener
+	bufferSize    int
+	defaultFilter []string
+
+	mu             sync.Mutex
+	clients        map[*serverClient]struct{}
+	droppedClients int
+}
+
+type serverClient struct {
+	conn     net.Conn
+	outgoing chan string
+	// Closed when the client is removed
+	done chan struct{}
+	// Closed when the queued sentences are to be sent before disconnecting
+	drain chan struct{}
+
+	mu     sync.Mutex
+	filter []string
+	closed bool
+	// Set when no more sentences are queued and the drain channel is closed
+	draining bool
+}
+
+// NewSentenceServer creates a server that accepts clients from listener. New
+// clients start out with defaultFilter, an empty filter passes all sentences.
+func NewSentenceServer(listener net.Listener, bufferSize int, defaultFilter []string) *SentenceServer {
+	return &SentenceServer{
+		listener:      listener,
+		bufferSize:    bufferSize,
+		defaultFilter: defaultFilter,
+		clients:       make(map[*serverClient]struct{}),
+	}
+}
+
+// Serve accepts client connections until the listener is closed.
+func (s *SentenceServer) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Client connected from %v", conn.RemoteAddr())
+		client := &serverClient{
+			conn:     conn,
+			outgoing: make(chan string, s.bufferSize),
+			done:     make(chan struct{}),
+			drain:    make(chan struct{}),
+			filter:   s.defaultFilter,
+		}
+
+		s.mu.Lock()
+		s.clients[client] = struct{}{}
+		s.mu.Unlock()
+
+		go s.writeToClient(client)
+		go s.readFromClient(client)
+	}
+}
+
+// Broadcast queues the sentence for all clients whose filter matches it.
+func (s *SentenceServer) Broadcast(sentence string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.clients {
+		if !s.queue(client, sentence) {
+			log.Printf("Client %v is too slow, disconnecting", client.conn.RemoteAddr())
+			s.droppedClients++
+			s.removeClient(client)
+		}
+	}
+}
+
+// BroadcastWait is like Broadcast, but waits for room in the buffers of slow
+// clients instead of disconnecting them, so that the sender is paced by the
+// slowest client. Clients that stop reading are still disconnected once a
+// write to them times out. If ctx is done first, the clients that are still
+// full miss the sentence and the context's error is returned.
+func (s *SentenceServer) BroadcastWait(ctx context.Context, sentence string) error {
+	s.mu.Lock()
+	var full []*serverClient
+	for client := range s.clients {
+		if !s.queue(client, sentence) {
+			full = append(full, client)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, client := range full {
+		select {
+		case client.outgoing <- sentence:
+		case <-client.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// queue adds the sentence to the client's buffer if the filter matches it, and
+// returns false if the buffer is full.
+func (s *SentenceServer) queue(client *serverClient, sentence string) bool {
+	client.mu.Lock()
+	filter := client.filter
+	draining := client.draining
+	client.mu.Unlock()
+
+	if draining || len(filter) > 0 && !MatchSentence(filter, sentence) {
+		return true
+	}
+
+	select {
+	case client.outgoing <- sentence:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumClients returns the number of currently connected clients.
+func (s *SentenceServer) NumClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+// DroppedClients returns the number of clients disconnected for being too slow.
+func (s *SentenceServer) DroppedClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.droppedClients
+}
+
+// Close stops accepting new clients and disconnects the existing ones.
+func (s *SentenceServer) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.clients {
+		s.removeClient(client)
+	}
+
+	return err
+}
+
+// Drain stops accepting new clients and waits up to timeout for the queued
+// sentences to be sent before disconnecting the clients.
+func (s *SentenceServer) Drain(timeout time.Duration) error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for client := range s.clients {
+		client.mu.Lock()
+		if !client.closed && !client.draining {
+			client.draining = true
+			close(client.drain)
+		}
+		client.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	// The clients remove themselves once everything has been written
+	deadline := time.Now().Add(timeout)
+	for s.NumClients() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Close()
+	return err
+}
+
+// removeClient must be called with s.mu held.
+func (s *SentenceServer) removeClient(client *serverClient) {
+	delete(s.clients, client)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if !client.closed {
+		client.closed = true
+		close(client.done)
+		client.conn.Close()
+	}
+}
+
+func (s *SentenceServer) disconnect(client *serverClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeClient(client)
+}
+
+func (s *SentenceServer) writeToClient(client *serverClient) {
+	defer s.disconnect(client)
+
+	for {
+		select {
+		case sentence := <-client.outgoing:
+			if !s.write(client, sentence) {
+				return
+			}
+		case <-client.done:
+			return
+		case <-client.drain:
+			// Send what's queued, then disconnect
+			for {
+				select {
+				case sentence := <-client.outgoing:
+					if !s.write(client, sentence) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write sends the sentence to the client and reports whether it succeeded.
+func (s *SentenceServer) write(client *serverClient, sentence string) bool {
+	client.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	if _, err := client.conn.Write([]byte(sentence + "\r\n")); err != nil {
+		log.Printf("Error writing to client %v: %v", client.conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+func (s *SentenceServer) readFromClient(client *serverClient) {
+	defer s.disconnect(client)
+
+	scanner := bufio.NewScanner(client.conn)
+	for scanner.Scan() {
+		command, args, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !strings.EqualFold(command, "FILTER") {
+			continue
+		}
+
+		filter := ParseSentenceFilter(args)
+		log.Printf("Client %v set filter to %v", client.conn.RemoteAddr(), filter)
+
+		client.mu.Lock()
+		client.filter = filter
+		client.mu.Unlock()
+	}
+
+	log.Printf("Client %v disconnected", client.conn.RemoteAddr())
+}
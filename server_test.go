@@ -0,0 +1,197 @@
+package nmealogger
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T, defaultFilter []string) *SentenceServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	server := NewSentenceServer(listener, 10, defaultFilter)
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	return server
+}
+
+func connectTestClient(t *testing.T, server *SentenceServer, expectedClients int) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	waitFor(t, func() bool { return server.NumClients() == expectedClients })
+
+	return conn, bufio.NewReader(conn)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for condition")
+}
+
+func readSentence(t *testing.T, conn net.Conn, reader *bufio.Reader) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error reading from server: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func TestSentenceServerBroadcast(t *testing.T) {
+	server := startTestServer(t, nil)
+
+	conn1, reader1 := connectTestClient(t, server, 1)
+	conn2, reader2 := connectTestClient(t, server, 2)
+
+	sentence := "$IIMWV,127,R,21.8,N,A*1C"
+	server.Broadcast(sentence)
+
+	if got := readSentence(t, conn1, reader1); got != sentence {
+		t.Errorf("Client 1 got %q, expected %q", got, sentence)
+	}
+	if got := readSentence(t, conn2, reader2); got != sentence {
+		t.Errorf("Client 2 got %q, expected %q", got, sentence)
+	}
+
+	conn1.Close()
+	waitFor(t, func() bool { return server.NumClients() == 1 })
+}
+
+func TestSentenceServerFilter(t *testing.T) {
+	server := startTestServer(t, []string{"MWV"})
+
+	conn, reader := connectTestClient(t, server, 1)
+
+	server.Broadcast("$IIVHW,,,117,M,05.7,N,,*61")
+	server.Broadcast("$IIMWV,127,R,21.8,N,A*1C")
+	if got := readSentence(t, conn, reader); got != "$IIMWV,127,R,21.8,N,A*1C" {
+		t.Errorf("Expected default filter to pass only MWV, got %q", got)
+	}
+
+	if _, err := conn.Write([]byte("FILTER VHW\r\n")); err != nil {
+		t.Fatalf("Error sending filter: %v", err)
+	}
+	waitFor(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		for client := range server.clients {
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			return len(client.filter) == 1 && client.filter[0] == "VHW"
+		}
+		return false
+	})
+
+	server.Broadcast("$IIMWV,127,R,21.8,N,A*1C")
+	server.Broadcast("$IIVHW,,,117,M,05.7,N,,*61")
+	if got := readSentence(t, conn, reader); got != "$IIVHW,,,117,M,05.7,N,,*61" {
+		t.Errorf("Expected client filter to pass only VHW, got %q", got)
+	}
+}
+
+func TestSentenceServerDrain(t *testing.T) {
+	server := startTestServer(t, nil)
+
+	conn, reader := connectTestClient(t, server, 1)
+
+	sentences := []string{"$IIVHW,,,117,M,05.7,N,,*61", "$IIMWV,127,R,21.8,N,A*1C"}
+	for _, sentence := range sentences {
+		server.Broadcast(sentence)
+	}
+	server.Drain(time.Second)
+
+	for _, sentence := range sentences {
+		if got := readSentence(t, conn, reader); got != sentence {
+			t.Errorf("Got %q, expected %q", got, sentence)
+		}
+	}
+	if server.NumClients() != 0 {
+		t.Errorf("Expected clients to be disconnected after drain, got %d", server.NumClients())
+	}
+}
+
+func TestSentenceServerDropsSlowClient(t *testing.T) {
+	server := startTestServer(t, nil)
+
+	// The client never reads, so once the socket buffers are full the
+	// sentences pile up in its send buffer
+	connectTestClient(t, server, 1)
+
+	sentence := "$IIVHW,,,117,M,05.7,N,,*61" + strings.Repeat(" ", 64*1024)
+	for i := 0; i < 100000 && server.NumClients() > 0; i++ {
+		server.Broadcast(sentence)
+	}
+
+	if server.NumClients() != 0 {
+		t.Errorf("Expected the slow client to be disconnected, got %d clients", server.NumClients())
+	}
+	if server.DroppedClients() != 1 {
+		t.Errorf("Expected 1 dropped client, got %d", server.DroppedClients())
+	}
+}
+
+func TestSentenceServerBroadcastWait(t *testing.T) {
+	server := startTestServer(t, nil)
+
+	conn, reader := connectTestClient(t, server, 1)
+
+	// Many times the buffer size, sent faster than the client reads them
+	const count = 10000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			server.BroadcastWait(context.Background(), "$IIVHW,,,117,M,05.7,N,,*61")
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		if got := readSentence(t, conn, reader); got != "$IIVHW,,,117,M,05.7,N,,*61" {
+			t.Fatalf("Sentence %d: got %q", i, got)
+		}
+	}
+	<-done
+	if server.NumClients() != 1 || server.DroppedClients() != 0 {
+		t.Errorf("Expected the slow client to stay connected, got %d clients and %d dropped", server.NumClients(), server.DroppedClients())
+	}
+}
+
+func TestSentenceServerBroadcastWaitCancel(t *testing.T) {
+	server := startTestServer(t, nil)
+
+	// The client never reads, so BroadcastWait blocks once the socket and
+	// the send buffers are full
+	connectTestClient(t, server, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sentence := "$IIVHW,,,117,M,05.7,N,,*61" + strings.Repeat(" ", 64*1024)
+	var err error
+	for i := 0; i < 100000 && err == nil; i++ {
+		err = server.BroadcastWait(ctx, sentence)
+	}
+
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected BroadcastWait to stop when the context is done, got %v", err)
+	}
+	if server.NumClients() != 1 || server.DroppedClients() != 0 {
+		t.Errorf("Expected the stalled client to stay connected, got %d clients and %d dropped", server.NumClients(), server.DroppedClients())
+	}
+}
@@ -0,0 +1,351 @@
+package nmealogger
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignalKTimeColumn is the first column of the signalk-logger CSV logs, the
+// others are named after the SignalK paths of the values.
+const SignalKTimeColumn = "time"
+
+const (
+	radiansToDegrees = 180 / math.Pi
+	kelvinToCelsius  = -273.15
+	kmhPerKnot       = 1.852
+	metersPerNM      = 1852
+)
+
+// SignalKRecord is a row of a signalk-logger CSV log with the values by
+// SignalK path, in the SI units of SignalK. Empty values are left out.
+type SignalKRecord struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// ParseSignalKHeader returns the columns of the header line of a
+// signalk-logger CSV log, or false if the line isn't one.
+func ParseSignalKHeader(line string) ([]string, bool) {
+	columns, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil || len(columns) < 2 || columns[0] != SignalKTimeColumn {
+		return nil, false
+	}
+	return columns, true
+}
+
+// ParseSignalKRecord parses a row of a signalk-logger CSV log, with the
+// columns from the header of the log.
+func ParseSignalKRecord(columns []string, line string) (SignalKRecord, error) {
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return SignalKRecord{}, err
+	}
+	if len(fields) != len(columns) {
+		return SignalKRecord{}, fmt.Errorf("expected %d columns, got %d", len(columns), len(fields))
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return SignalKRecord{}, fmt.Errorf("error parsing record time: %w", err)
+	}
+
+	record := SignalKRecord{Time: t, Values: make(map[string]float64)}
+	for i, field := range fields[1:] {
+		if field == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return SignalKRecord{}, fmt.Errorf("error parsing %s: %w", columns[i+1], err)
+		}
+		record.Values[columns[i+1]] = value
+	}
+	return record, nil
+}
+
+// SignalKToNMEA synthesizes RMC, VHW, HDG, MWV, DPT, MTW, ROT and XDR sentences
+// from the values of the record, converted to the NMEA 0183 units. A sentence
+// is left out if the values it needs are missing.
+func SignalKToNMEA(record SignalKRecord) []string {
+	values := record.Values
+	t := record.Time.UTC()
+
+	var sentences []string
+	add := func(format string, args ...any) {
+		data := fmt.Sprintf(format, args...)
+		sentences = append(sentences, "$"+data+"*"+CalculateChecksum(data))
+	}
+	// Optional fields are left empty
+	field := func(path string, scale, offset float64) string {
+		value, ok := values[path]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf("%.1f", value*scale+offset)
+	}
+	angle := func(path string) string {
+		value, ok := values[path]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf("%.1f", normalizeDegrees(value*radiansToDegrees))
+	}
+
+	// Magnetic variation and its direction, east is positive
+	variation := ","
+	if value, ok := values["navigation.magneticVariation"]; ok {
+		direction := "E"
+		if value < 0 {
+			direction = "W"
+		}
+		variation = fmt.Sprintf("%.1f,%s", math.Abs(value*radiansToDegrees), direction)
+	}
+
+	latitude, hasLatitude := values["navigation.position.latitude"]
+	longitude, hasLongitude := values["navigation.position.longitude"]
+	if hasLatitude && hasLongitude {
+		// $GPRMC,hhmmss.ss,A,llll.ll,a,yyyyy.yy,a,x.x,x.x,ddmmyy,x.x,a,a*hh
+		add("GPRMC,%s,A,%s,%s,%s,%s,%s,%s,A", t.Format("150405"),
+			formatCoordinate(latitude, 2, "N", "S"), formatCoordinate(longitude, 3, "E", "W"),
+			field("navigation.speedOverGround", knotsPerMS, 0), angle("navigation.courseOverGroundTrue"),
+			t.Format("020106"), variation)
+	}
+
+	if _, ok := values["navigation.speedThroughWater"]; ok {
+		headingTrue := ""
+		heading, hasHeading := values["navigation.headingMagnetic"]
+		if value, ok := values["navigation.magneticVariation"]; ok && hasHeading {
+			headingTrue = fmt.Sprintf("%.1f", normalizeDegrees((heading+value)*radiansToDegrees))
+		}
+		// $IIVHW,x.x,T,x.x,M,x.x,N,x.x,K*hh
+		add("IIVHW,%s,T,%s,M,%s,N,%s,K", headingTrue, angle("navigation.headingMagnetic"),
+			field("navigation.speedThroughWater", knotsPerMS, 0),
+			field("navigation.speedThroughWater", knotsPerMS*kmhPerKnot, 0))
+	}
+
+	if _, ok := values["navigation.headingMagnetic"]; ok {
+		// $IIHDG,x.x,x.x,a,x.x,a*hh
+		add("IIHDG,%s,,,%s", angle("navigation.headingMagnetic"), variation)
+	}
+
+	_, hasWindAngle := values["environment.wind.angleApparent"]
+	_, hasWindSpeed := values["environment.wind.speedApparent"]
+	if hasWindAngle && hasWindSpeed {
+		// $IIMWV,x.x,a,x.x,a,A*hh
+		add("IIMWV,%s,R,%s,N,A", angle("environment.wind.angleApparent"),
+			field("environment.wind.speedApparent", knotsPerMS, 0))
+	}
+
+	if _, ok := values["environment.depth.belowTransducer"]; ok {
+		// $IIDPT,x.x,x.x*hh, a zero offset as the depth is from the transducer
+		add("IIDPT,%s,0.0", field("environment.depth.belowTransducer", 1, 0))
+	}
+
+	if _, ok := values["environment.water.temperature"]; ok {
+		// $IIMTW,x.x,C*hh
+		add("IIMTW,%s,C", field("environment.water.temperature", 1, kelvinToCelsius))
+	}
+
+	if _, ok := values["navigation.rateOfTurn"]; ok {
+		// $IIROT,x.x,A*hh, degrees per minute
+		add("IIROT,%s,A", field("navigation.rateOfTurn", radiansToDegrees*60, 0))
+	}
+
+	// $IIXDR,A,x.x,D,PTCH,A,x.x,D,ROLL*hh
+	var measurements []string
+	for _, attitude := range []struct{ path, name string }{
+		{"navigation.attitude.pitch", "PTCH"},
+		{"navigation.attitude.roll", "ROLL"},
+		{"navigation.attitude.yaw", "YAW"},
+	} {
+		if value := field(attitude.path, radiansToDegrees, 0); value != "" {
+			measurements = append(measurements, "A,"+value+",D,"+attitude.name)
+		}
+	}
+	if len(measurements) > 0 {
+		add("IIXDR,%s", strings.Join(measurements, ","))
+	}
+
+	return sentences
+}
+
+// normalizeDegrees returns the angle in the range [0, 360).
+func normalizeDegrees(degrees float64) float64 {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	// Rounding to a decimal can still make it 360.0
+	if degrees >= 359.95 {
+		degrees = 0
+	}
+	return degrees
+}
+
+// SignalKValue is the value of a SignalK path, a float64 in SI units or an
+// object such as the position.
+type SignalKValue struct {
+	Path  string
+	Value any
+}
+
+// SentenceToSignalK converts the data in an RMC, GGA, GLL, VTG, VHW, HDG,
+// HDM, HDT, MWV, DPT, DBT, MTW, VLW, ROT or XDR attitude sentence to the
+// SignalK paths and units. Other sentences and void data give no values.
+func SentenceToSignalK(sentence string) []SignalKValue {
+	_, sentenceType, ok := SentenceID(sentence)
+	if !ok {
+		return nil
+	}
+	fields, ok := DecodeSentence(sentence)
+	if !ok {
+		return nil
+	}
+
+	var values []SignalKValue
+	add := func(path string, value any) {
+		values = append(values, SignalKValue{Path: path, Value: value})
+	}
+	number := func(name string) (float64, bool) {
+		value, ok := fields[name].(float64)
+		return value, ok
+	}
+	// Adds the field converted with scale, if it's there
+	addNumber := func(path, name string, scale float64) {
+		if value, ok := number(name); ok {
+			add(path, value*scale)
+		}
+	}
+	addPosition := func() {
+		latitude, hasLatitude := number("latitude")
+		longitude, hasLongitude := number("longitude")
+		if hasLatitude && hasLongitude {
+			add("navigation.position", map[string]any{"latitude": latitude, "longitude": longitude})
+		}
+	}
+	addVariation := func(name, directionName string) {
+		if value, ok := number(name); ok {
+			if fields[directionName] == "W" {
+				value = -value
+			}
+			add("navigation.magneticVariation", value/radiansToDegrees)
+		}
+	}
+
+	switch sentenceType {
+	case "RMC":
+		if fields["status"] != "A" {
+			return nil
+		}
+		addPosition()
+		addNumber("navigation.speedOverGround", "speedOverGround", 1/knotsPerMS)
+		addNumber("navigation.courseOverGroundTrue", "courseOverGround", 1/radiansToDegrees)
+		addVariation("magneticVariation", "magneticVariationDirection")
+		date, hasDate := fields["date"].(string)
+		timeOfDay, hasTime := fields["time"].(string)
+		if hasDate && hasTime {
+			if t, err := time.Parse("2006-01-02 15:04:05.999", date+" "+timeOfDay); err == nil {
+				add("navigation.datetime", t.Format("2006-01-02T15:04:05.000Z"))
+			}
+		}
+	case "GGA":
+		if quality, _ := fields["quality"].(int); quality > 0 {
+			addPosition()
+		}
+	case "GLL":
+		if fields["status"] == "A" {
+			addPosition()
+		}
+	case "VTG":
+		addNumber("navigation.courseOverGroundTrue", "courseTrue", 1/radiansToDegrees)
+		addNumber("navigation.courseOverGroundMagnetic", "courseMagnetic", 1/radiansToDegrees)
+		addNumber("navigation.speedOverGround", "speedKnots", 1/knotsPerMS)
+	case "VHW":
+		addNumber("navigation.headingTrue", "headingTrue", 1/radiansToDegrees)
+		addNumber("navigation.headingMagnetic", "headingMagnetic", 1/radiansToDegrees)
+		addNumber("navigation.speedThroughWater", "speedKnots", 1/knotsPerMS)
+	case "HDG":
+		addNumber("navigation.headingMagnetic", "heading", 1/radiansToDegrees)
+		addVariation("variation", "variationDirection")
+	case "HDM":
+		addNumber("navigation.headingMagnetic", "headingMagnetic", 1/radiansToDegrees)
+	case "HDT":
+		addNumber("navigation.headingTrue", "headingTrue", 1/radiansToDegrees)
+	case "MWV":
+		angle, hasAngle := number("windAngle")
+		speed, hasSpeed := number("windSpeed")
+		if fields["status"] != "A" || !hasAngle || !hasSpeed {
+			return nil
+		}
+		switch fields["windSpeedUnit"] {
+		case "N":
+			speed /= knotsPerMS
+		case "K":
+			speed /= 3.6
+		case "M":
+		default:
+			return nil
+		}
+		// SignalK has the angle from -180 to 180 degrees, negative to port
+		if angle > 180 {
+			angle -= 360
+		}
+		if fields["reference"] == "R" {
+			add("environment.wind.angleApparent", angle/radiansToDegrees)
+			add("environment.wind.speedApparent", speed)
+		} else {
+			add("environment.wind.angleTrueWater", angle/radiansToDegrees)
+			add("environment.wind.speedTrue", speed)
+		}
+	case "DPT":
+		depth, ok := number("depth")
+		if !ok {
+			return nil
+		}
+		add("environment.depth.belowTransducer", depth)
+		// A positive offset is to the waterline and a negative one to the keel
+		if offset, _ := number("offset"); offset > 0 {
+			add("environment.depth.belowSurface", depth+offset)
+		} else if offset < 0 {
+			add("environment.depth.belowKeel", depth+offset)
+		}
+	case "DBT":
+		addNumber("environment.depth.belowTransducer", "depthMeters", 1)
+	case "MTW":
+		if temperature, ok := number("temperature"); ok && fields["temperatureUnit"] == "C" {
+			add("environment.water.temperature", temperature-kelvinToCelsius)
+		}
+	case "VLW":
+		addNumber("navigation.log", "totalDistance", metersPerNM)
+		addNumber("navigation.trip.log", "tripDistance", metersPerNM)
+	case "ROT":
+		if fields["status"] == "A" {
+			addNumber("navigation.rateOfTurn", "rateOfTurn", 1/radiansToDegrees/60)
+		}
+	case "XDR":
+		attitude := make(map[string]any)
+		measurements, _ := fields["measurements"].([]map[string]any)
+		for _, measurement := range measurements {
+			value, ok := measurement["value"].(float64)
+			if !ok || measurement["type"] != "A" || measurement["unit"] != "D" {
+				continue
+			}
+			switch measurement["name"] {
+			case "PTCH", "PITCH":
+				attitude["pitch"] = value / radiansToDegrees
+			case "ROLL":
+				attitude["roll"] = value / radiansToDegrees
+			case "YAW":
+				attitude["yaw"] = value / radiansToDegrees
+			}
+		}
+		if len(attitude) > 0 {
+			add("navigation.attitude", attitude)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,126 @@
+package nmealogger
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignalKToNMEA(t *testing.T) {
+	columns, ok := ParseSignalKHeader("time,navigation.position.latitude,navigation.position.longitude," +
+		"navigation.speedOverGround,navigation.courseOverGroundTrue,navigation.magneticVariation," +
+		"navigation.speedThroughWater,navigation.headingMagnetic,environment.wind.angleApparent," +
+		"environment.wind.speedApparent,environment.depth.belowTransducer,environment.water.temperature," +
+		"navigation.attitude.pitch,navigation.attitude.roll,navigation.attitude.yaw")
+	if !ok {
+		t.Fatalf("Expected a SignalK header")
+	}
+	if _, ok := ParseSignalKHeader("2024-07-15T13:09:49.000+0000\t$IIMWV,127,R,21.8,N,A*1C"); ok {
+		t.Errorf("Expected an NMEA log line not to be a SignalK header")
+	}
+
+	record, err := ParseSignalKRecord(columns, "2024-07-15T16:09:49+03:00,59.516167,24.771917,"+
+		"2.932000,2.792527,0.122173,2.932000,1.745329,-0.523599,10.000000,12.340000,288.150000,0.034907,-0.087266,")
+	if err != nil {
+		t.Fatalf("Error parsing record: %v", err)
+	}
+	if !record.Time.Equal(time.Date(2024, 7, 15, 13, 9, 49, 0, time.UTC)) {
+		t.Errorf("Expected record time 13:09:49 UTC, got %v", record.Time)
+	}
+	if _, ok := record.Values["navigation.attitude.yaw"]; ok {
+		t.Errorf("Expected the empty yaw to be left out")
+	}
+
+	expected := []string{
+		"GPRMC,130949,A,5930.9700,N,02446.3150,E,5.7,160.0,150724,7.0,E,A",
+		"IIVHW,107.0,T,100.0,M,5.7,N,10.6,K",
+		"IIHDG,100.0,,,7.0,E",
+		"IIMWV,330.0,R,19.4,N,A",
+		"IIDPT,12.3,0.0",
+		"IIMTW,15.0,C",
+		"IIXDR,A,2.0,D,PTCH,A,-5.0,D,ROLL",
+	}
+	for i, data := range expected {
+		expected[i] = "$" + data + "*" + CalculateChecksum(data)
+	}
+	if sentences := SignalKToNMEA(record); !reflect.DeepEqual(sentences, expected) {
+		t.Errorf("Expected sentences\n%v\ngot\n%v", expected, sentences)
+	}
+
+	// Only the sentences that have their values
+	record.Values = map[string]float64{"environment.wind.angleApparent": 0.5, "navigation.magneticVariation": -0.1}
+	if sentences := SignalKToNMEA(record); len(sentences) != 0 {
+		t.Errorf("Expected no sentences without complete values, got %v", sentences)
+	}
+
+	if _, err := ParseSignalKRecord(columns, "2024-07-15T16:09:49+03:00,59.516167"); err == nil {
+		t.Errorf("Expected an error for missing columns")
+	}
+}
+
+func TestSentenceToSignalK(t *testing.T) {
+	tests := []struct {
+		sentence string
+		expected map[string]any
+	}{
+		{
+			"$GPRMC,130949.50,A,5930.970,N,02446.315,W,05.7,160,150724,7.0,W,A*1F",
+			map[string]any{
+				"navigation.position":             map[string]any{"latitude": 59.5162, "longitude": -24.7719},
+				"navigation.speedOverGround":      2.9323,
+				"navigation.courseOverGroundTrue": 2.7925,
+				"navigation.magneticVariation":    -0.1222,
+				"navigation.datetime":             "2024-07-15T13:09:49.500Z",
+			},
+		},
+		{"$GPRMC,130949,V,,,,,,,150724,,,N*1F", map[string]any{}},
+		{"$GPGGA,130949,5930.970,N,02446.315,E,0,00,,,M,,M,,*4F", map[string]any{}},
+		{
+			"$IIMWV,270.0,R,10.0,M,A*1C",
+			map[string]any{"environment.wind.angleApparent": -1.5708, "environment.wind.speedApparent": 10.0},
+		},
+		{
+			"$IIMWV,45.0,T,36.0,K,A*1C",
+			map[string]any{"environment.wind.angleTrueWater": 0.7854, "environment.wind.speedTrue": 10.0},
+		},
+		{"$IIMWV,45.0,T,36.0,K,V*1C", map[string]any{}},
+		{
+			"$IIDPT,12.3,-1.3*1C",
+			map[string]any{"environment.depth.belowTransducer": 12.3, "environment.depth.belowKeel": 11.0},
+		},
+		{"$IIMTW,15.0,C*1C", map[string]any{"environment.water.temperature": 288.15}},
+		{"$IIVLW,1.5,N,0.5,N*1C", map[string]any{"navigation.log": 2778.0, "navigation.trip.log": 926.0}},
+		{"$IIROT,-60.0,A*1C", map[string]any{"navigation.rateOfTurn": -0.01745}},
+		{
+			"$IIXDR,A,2.0,D,PTCH,A,-5.0,D,ROLL,C,19.5,C,TempAir*1C",
+			map[string]any{"navigation.attitude": map[string]any{"pitch": 0.0349, "roll": -0.0873}},
+		},
+		{"$IIVWR,127,R,21.8,N,,,,*7A", map[string]any{}},
+	}
+
+	for _, test := range tests {
+		got := make(map[string]any)
+		for _, value := range SentenceToSignalK(test.sentence) {
+			got[value.Path] = roundValue(value.Value)
+		}
+		if !reflect.DeepEqual(got, roundValue(test.expected)) {
+			t.Errorf("SentenceToSignalK(%q) = %v, expected %v", test.sentence, got, test.expected)
+		}
+	}
+}
+
+// roundValue rounds the numbers in the value to 4 decimals for comparing.
+func roundValue(value any) any {
+	switch v := value.(type) {
+	case float64:
+		return math.Round(v*1e4) / 1e4
+	case map[string]any:
+		rounded := make(map[string]any)
+		for key, value := range v {
+			rounded[key] = roundValue(value)
+		}
+		return rounded
+	}
+	return value
+}
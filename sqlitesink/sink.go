@@ -0,0 +1,337 @@
+// Package sqlitesink stores decoded NMEA sentences and SignalK values in a
+// SQLite database for querying with SQL.
+//
+// Each quantity has its own table with the columns time, source and value,
+// eg. nmea_RMC_speedOverGround or signalk_navigation_speedOverGround. MWV wind
+// is stored by reference with the speed in knots, eg. nmea_MWV_T_windSpeed.
+// The time is in milliseconds since the Unix epoch, use
+// datetime(time / 1000.0, 'unixepoch') to make it readable. NMEA sentences are
+// also stored as is, with the decoded fields as JSON, in the sentences table.
+package sqlitesink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	// Writes are batched in a transaction that is committed this often
+	CommitInterval = time.Second
+	// Old data is deleted this often when retention is enabled
+	RetentionInterval = time.Hour
+)
+
+const sentencesTable = "sentences"
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Sink writes to a SQLite database. It's not safe for concurrent use.
+type Sink struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	tables map[string]bool
+	// Tables created in the current transaction, forgotten if it's rolled back
+	created   []string
+	retention time.Duration
+
+	lastCommit  time.Time
+	lastCleanup time.Time
+}
+
+// Open opens or creates the database. Data older than retention is deleted
+// periodically, a zero retention keeps everything.
+func Open(path string, retention time.Duration) (*Sink, error) {
+	// WAL lets queries run while the logger is writing
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	s := &Sink{
+		db:          db,
+		tables:      make(map[string]bool),
+		retention:   retention,
+		lastCommit:  time.Now(),
+		lastCleanup: time.Now(),
+	}
+
+	if err := s.loadTables(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + sentencesTable + ` (
+		time INTEGER NOT NULL, talker TEXT, type TEXT, sentence TEXT NOT NULL, fields TEXT)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sentences table: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS ` + sentencesTable + `_time ON ` + sentencesTable + ` (time)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sentences table: %w", err)
+	}
+	s.tables[sentencesTable] = true
+
+	return s, nil
+}
+
+func (s *Sink) loadTables() error {
+	rows, err := s.db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		// Leave alone any tables that were added for analysis
+		if strings.HasPrefix(name, "nmea_") || strings.HasPrefix(name, "signalk_") {
+			s.tables[name] = true
+		}
+	}
+	return rows.Err()
+}
+
+// WriteSentence stores the sentence and its decoded fields received at time
+// t. Numeric fields are also stored in the table of the quantity, named after
+// the sentence type and field, eg. nmea_MWV_windAngle.
+func (s *Sink) WriteSentence(t time.Time, sentence string) error {
+	talker, sentenceType, ok := nmealogger.SentenceID(sentence)
+	if !ok {
+		return nil
+	}
+
+	fields, _ := nmealogger.DecodeSentence(sentence)
+	var fieldsJSON any
+	if fields != nil {
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		fieldsJSON = string(data)
+	}
+
+	if err := s.exec(`INSERT INTO `+sentencesTable+` (time, talker, type, sentence, fields) VALUES (?, ?, ?, ?, ?)`,
+		t.UnixMilli(), talker, sentenceType, sentence, fieldsJSON); err != nil {
+		return err
+	}
+
+	for quantity, value := range numericFields(sentenceType, fields) {
+		if err := s.writeValue(t, quantity, talker, value); err != nil {
+			return err
+		}
+	}
+
+	// XDR measurements are stored by the transducer name
+	if measurements, ok := fields["measurements"].([]map[string]any); ok {
+		for _, measurement := range measurements {
+			name, _ := measurement["name"].(string)
+			value, ok := measurement["value"].(float64)
+			if name == "" || !ok {
+				continue
+			}
+			if err := s.writeValue(t, "nmea_XDR_"+name, talker, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.maybeCommit()
+}
+
+// WriteValue stores a SignalK value received at time t from the source in
+// the table of the path, eg. signalk_navigation_speedOverGround.
+func (s *Sink) WriteValue(t time.Time, path string, source string, value float64) error {
+	if err := s.writeValue(t, "signalk_"+path, source, value); err != nil {
+		return err
+	}
+	return s.maybeCommit()
+}
+
+func (s *Sink) writeValue(t time.Time, quantity string, source string, value float64) error {
+	table := invalidNameChars.ReplaceAllString(quantity, "_")
+	if !s.tables[table] {
+		if err := s.createTable(table); err != nil {
+			return err
+		}
+	}
+
+	return s.exec(`INSERT INTO "`+table+`" (time, source, value) VALUES (?, ?, ?)`, t.UnixMilli(), source, value)
+}
+
+func (s *Sink) createTable(table string) error {
+	if err := s.exec(`CREATE TABLE IF NOT EXISTS "` + table + `" (time INTEGER NOT NULL, source TEXT, value REAL)`); err != nil {
+		return fmt.Errorf("error creating table %s: %w", table, err)
+	}
+	if err := s.exec(`CREATE INDEX IF NOT EXISTS "` + table + `_time" ON "` + table + `" (time)`); err != nil {
+		return fmt.Errorf("error creating table %s: %w", table, err)
+	}
+	s.tables[table] = true
+	s.created = append(s.created, table)
+	return nil
+}
+
+// exec runs the statement in the current transaction, starting one if needed.
+// On error the transaction is rolled back, losing the uncommitted data, so
+// that the next write starts a fresh one.
+func (s *Sink) exec(query string, args ...any) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+
+	if _, err := s.tx.Exec(query, args...); err != nil {
+		s.rollback()
+		return err
+	}
+	return nil
+}
+
+func (s *Sink) rollback() {
+	if err := s.tx.Rollback(); err != nil {
+		log.Printf("Error rolling back the database transaction: %v", err)
+	}
+	s.tx = nil
+	s.forgetCreated()
+	s.created = nil
+}
+
+// forgetCreated removes the tables created in a failed transaction, so that
+// they are created again.
+func (s *Sink) forgetCreated() {
+	for _, table := range s.created {
+		delete(s.tables, table)
+	}
+}
+
+func (s *Sink) maybeCommit() error {
+	if time.Since(s.lastCommit) < CommitInterval {
+		return nil
+	}
+	if err := s.Commit(); err != nil {
+		return err
+	}
+
+	if s.retention > 0 && time.Since(s.lastCleanup) >= RetentionInterval {
+		s.lastCleanup = time.Now()
+		deleted, err := s.DeleteBefore(time.Now().Add(-s.retention))
+		if err != nil {
+			return fmt.Errorf("error deleting old data: %w", err)
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d rows older than %v from the database", deleted, s.retention)
+		}
+	}
+
+	return nil
+}
+
+// Commit writes the pending data to the database.
+func (s *Sink) Commit() error {
+	s.lastCommit = time.Now()
+	if s.tx == nil {
+		return nil
+	}
+
+	err := s.tx.Commit()
+	s.tx = nil
+	if err != nil {
+		s.forgetCreated()
+	}
+	s.created = nil
+	return err
+}
+
+// DeleteBefore deletes the data older than t from all the tables and returns
+// the number of rows deleted.
+func (s *Sink) DeleteBefore(t time.Time) (int64, error) {
+	if err := s.Commit(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for table := range s.tables {
+		result, err := s.db.Exec(`DELETE FROM "`+table+`" WHERE time < ?`, t.UnixMilli())
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting from %s: %w", table, err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// Close commits the pending data and closes the database.
+func (s *Sink) Close() error {
+	commitErr := s.Commit()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return commitErr
+}
+
+// Conversions of the MWV wind speed units to knots
+var windSpeedToKnots = map[string]float64{
+	"N": 1,
+	"K": 1000.0 / 1852,
+	"M": 3600.0 / 1852,
+	"S": 1609.344 / 1852,
+}
+
+// numericFields returns the numeric fields of the sentence by quantity name.
+// MWV wind is split by its reference, relative (R) or true (T), and the speed
+// is converted to knots, so that a table doesn't mix apparent and true wind
+// or different units.
+func numericFields(sentenceType string, fields map[string]any) map[string]float64 {
+	prefix := "nmea_" + sentenceType + "_"
+	if sentenceType == "MWV" {
+		reference, _ := fields["reference"].(string)
+		if reference != "R" && reference != "T" {
+			return nil
+		}
+		prefix += reference + "_"
+	}
+
+	values := make(map[string]float64)
+	for name, value := range fields {
+		if number, ok := toFloat(value); ok {
+			values[prefix+name] = number
+		}
+	}
+
+	if speed, ok := values[prefix+"windSpeed"]; ok && sentenceType == "MWV" {
+		unit, _ := fields["windSpeedUnit"].(string)
+		if factor, ok := windSpeedToKnots[unit]; ok {
+			values[prefix+"windSpeed"] = speed * factor
+		} else {
+			delete(values, prefix+"windSpeed")
+		}
+	}
+	return values
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
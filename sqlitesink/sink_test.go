@@ -0,0 +1,143 @@
+package sqlitesink
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nmea.db")
+	sink, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	for i, sentence := range []string{
+		"$IIMWV,127,R,21.8,N,A*1C",
+		"$IIMWV,130,R,22.1,N,A*1C",
+		"$IIXDR,C,19.52,C,TempAir*2F",
+		"$IIMWV,045,T,10.0,M,A*16",
+		"$IIMWV,050,T,36.0,K,A*10",
+		"$IIMWV,060,T,5.0,X,A*30",
+	} {
+		if err := sink.WriteSentence(start.Add(time.Duration(i)*time.Second), sentence); err != nil {
+			t.Fatalf("Error writing sentence: %v", err)
+		}
+	}
+	if err := sink.WriteValue(start, "navigation.speedOverGround", "can0.85", 3.1); err != nil {
+		t.Fatalf("Error writing value: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Error closing database: %v", err)
+	}
+
+	// Reopen to check that the data was committed and the tables are found
+	sink, err = Open(path, 0)
+	if err != nil {
+		t.Fatalf("Error reopening database: %v", err)
+	}
+	defer sink.Close()
+
+	var count int
+	var maxAngle float64
+	if err := sink.db.QueryRow(`SELECT count(*), max(value) FROM nmea_MWV_R_windAngle WHERE source = 'II'`).Scan(&count, &maxAngle); err != nil {
+		t.Fatalf("Error querying wind angle: %v", err)
+	}
+	if count != 2 || maxAngle != 130 {
+		t.Errorf("Expected 2 apparent wind angles up to 130, got %d up to %v", count, maxAngle)
+	}
+	if err := sink.db.QueryRow(`SELECT count(*) FROM nmea_MWV_T_windAngle`).Scan(&count); err != nil || count != 3 {
+		t.Errorf("Expected 3 true wind angles, got %d, %v", count, err)
+	}
+
+	// 10 m/s and 36 km/h in knots, the speed with an unknown unit is left out
+	var minSpeed, maxSpeed float64
+	if err := sink.db.QueryRow(`SELECT count(*), min(value), max(value) FROM nmea_MWV_T_windSpeed`).Scan(&count, &minSpeed, &maxSpeed); err != nil {
+		t.Fatalf("Error querying true wind speed: %v", err)
+	}
+	if count != 2 || math.Abs(minSpeed-19.438) > 0.001 || math.Abs(maxSpeed-19.438) > 0.001 {
+		t.Errorf("Expected 2 true wind speeds of 19.438 knots, got %d from %v to %v", count, minSpeed, maxSpeed)
+	}
+	if err := sink.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'nmea_MWV_windAngle'`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected no table mixing apparent and true wind, got %d, %v", count, err)
+	}
+
+	var reference string
+	if err := sink.db.QueryRow(`SELECT json_extract(fields, '$.reference') FROM sentences WHERE type = 'MWV' LIMIT 1`).Scan(&reference); err != nil {
+		t.Fatalf("Error querying sentences: %v", err)
+	}
+	if reference != "R" {
+		t.Errorf("Expected reference R in the decoded fields, got %q", reference)
+	}
+
+	var temperature float64
+	if err := sink.db.QueryRow(`SELECT value FROM nmea_XDR_TempAir`).Scan(&temperature); err != nil {
+		t.Fatalf("Error querying XDR temperature: %v", err)
+	}
+	if temperature != 19.52 {
+		t.Errorf("Expected air temperature 19.52, got %v", temperature)
+	}
+
+	var sogTime int64
+	if err := sink.db.QueryRow(`SELECT time FROM signalk_navigation_speedOverGround WHERE source = 'can0.85'`).Scan(&sogTime); err != nil {
+		t.Fatalf("Error querying SignalK value: %v", err)
+	}
+	if sogTime != start.UnixMilli() {
+		t.Errorf("Expected time %d, got %d", start.UnixMilli(), sogTime)
+	}
+
+	deleted, err := sink.DeleteBefore(start.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Error deleting old data: %v", err)
+	}
+	// The first MWV sentence, its angle and speed and the SignalK value
+	if deleted != 4 {
+		t.Errorf("Expected 4 rows deleted, got %d", deleted)
+	}
+}
+
+func TestSinkRecoversFromError(t *testing.T) {
+	sink, err := Open(filepath.Join(t.TempDir(), "nmea.db"), 0)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer sink.Close()
+
+	start := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	if err := sink.WriteValue(start, "navigation.speedOverGround", "can0.85", 3.1); err != nil {
+		t.Fatalf("Error writing value: %v", err)
+	}
+	// A failing statement rolls back the transaction, including the table
+	// created in it
+	if err := sink.exec(`INSERT INTO missing (time) VALUES (1)`); err == nil {
+		t.Fatalf("Expected an error inserting into a missing table")
+	}
+	if sink.tx != nil {
+		t.Errorf("Expected the failed transaction to be discarded")
+	}
+
+	if err := sink.WriteValue(start.Add(time.Second), "navigation.speedOverGround", "can0.85", 3.2); err != nil {
+		t.Fatalf("Error writing value after the error: %v", err)
+	}
+	if err := sink.WriteSentence(start.Add(time.Second), "$IIMWV,127,R,21.8,N,A*1C"); err != nil {
+		t.Fatalf("Error writing sentence after the error: %v", err)
+	}
+	if err := sink.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+
+	var count int
+	var sog float64
+	if err := sink.db.QueryRow(`SELECT count(*), max(value) FROM signalk_navigation_speedOverGround`).Scan(&count, &sog); err != nil {
+		t.Fatalf("Error querying SignalK value: %v", err)
+	}
+	if count != 1 || sog != 3.2 {
+		t.Errorf("Expected only the value written after the error, got %d up to %v", count, sog)
+	}
+	if err := sink.db.QueryRow(`SELECT count(*) FROM sentences`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the sentence written after the error, got %d, %v", count, err)
+	}
+}
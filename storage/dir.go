@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores the files in a local directory, or a remote one that is mounted
+// locally such as an NFS or SMB share.
+type Dir struct {
+	dir string
+}
+
+func NewDir(dir string) *Dir {
+	return &Dir{dir: dir}
+}
+
+func (d *Dir) List(ctx context.Context) ([]File, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, e := range entries {
+		// Skip directories and unfinished uploads
+		if !e.Type().IsRegular() || filepath.Ext(e.Name()) == ".tmp" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// Upload writes the file under a temporary name and renames it when complete,
+// so that an interrupted upload doesn't leave a partial file.
+func (d *Dir) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	tmp, err := os.CreateTemp(d.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(name))
+}
+
+// ResumeUpload writes the file to name.tmp, which is the session, and renames
+// it when complete. A resumed upload appends to the file.
+func (d *Dir) ResumeUpload(ctx context.Context, name string, r io.ReaderAt, size int64, session string, started func(session string) error) error {
+	tmpName := d.path(name) + ".tmp"
+	resume := session != ""
+	if !resume {
+		if err := started(filepath.Base(tmpName)); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	err = writeRest(file, r, size, resume)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, d.path(name))
+}
+
+func (d *Dir) Download(ctx context.Context, name string, w io.Writer) error {
+	file, err := os.Open(d.path(name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func (d *Dir) Delete(ctx context.Context, name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d *Dir) Stat(ctx context.Context, name string) (File, error) {
+	file, err := os.Open(d.path(name))
+	if err != nil {
+		return File{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return File{}, err
+	}
+	md5, err := Checksum(file)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, Size: info.Size(), ModTime: info.ModTime(), MD5: md5}, nil
+}
+
+func (d *Dir) String() string {
+	return d.dir
+}
+
+func (d *Dir) Close() error {
+	return nil
+}
+
+func (d *Dir) path(name string) string {
+	return filepath.Join(d.dir, filepath.Base(name))
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+const (
+	// DriveChunkSize is the size of the chunks of resumable uploads, Drive
+	// requires a multiple of 256 KiB.
+	DriveChunkSize = 8 * 256 * 1024
+
+	driveFileFields = "id, name, size, modifiedTime, md5Checksum"
+	driveUploadURL  = "https://www.googleapis.com/upload/drive/v3/files"
+)
+
+// errUploadExpired is returned for resumable upload sessions Drive doesn't
+// know, they expire after a week.
+var errUploadExpired = errors.New("upload session expired")
+
+// Drive stores the files in a Google Drive folder that is shared with a
+// service account. Drive allows several files with the same name, the newest
+// one is used.
+type Drive struct {
+	// Authenticated client for the resumable uploads, which the Drive
+	// package doesn't expose
+	client   *http.Client
+	srv      *drive.Service
+	folderID string
+	// Where the resumable uploads are started and the size of their chunks,
+	// fixed except in tests
+	uploadURL string
+	chunkSize int64
+}
+
+func NewDrive(ctx context.Context, c *nmealogger.DriveConfig) (*Drive, error) {
+	client, _, err := htransport.NewClient(ctx, option.WithCredentialsFile(c.Credentials), option.WithScopes(drive.DriveScope))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Drive client: %w", err)
+	}
+	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Drive client: %w", err)
+	}
+	return &Drive{client: client, srv: srv, folderID: c.FolderID, uploadURL: driveUploadURL, chunkSize: DriveChunkSize}, nil
+}
+
+func (d *Drive) List(ctx context.Context) ([]File, error) {
+	driveFiles, err := d.list(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(driveFiles))
+	for _, f := range driveFiles {
+		files = append(files, driveFile(f))
+	}
+	return files, nil
+}
+
+func (d *Drive) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	existing, err := d.find(ctx, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if existing != nil {
+		_, err = d.srv.Files.Update(existing.Id, &drive.File{}).Media(r).Context(ctx).Do()
+	} else {
+		_, err = d.srv.Files.Create(&drive.File{Name: name, Parents: []string{d.folderID}}).Media(r).Context(ctx).Do()
+	}
+	return err
+}
+
+// ResumeUpload uses the Drive resumable upload protocol, the session is the
+// URL of the upload. The file is sent in chunks of DriveChunkSize and a
+// resumed upload continues from the last chunk Drive has received.
+func (d *Drive) ResumeUpload(ctx context.Context, name string, r io.ReaderAt, size int64, session string, started func(session string) error) error {
+	if size == 0 {
+		return d.Upload(ctx, name, io.NewSectionReader(r, 0, 0), 0)
+	}
+
+	var offset int64
+	if session != "" {
+		var done bool
+		var err error
+		offset, done, err = d.sendChunk(ctx, session, nil, 0, size)
+		if errors.Is(err, errUploadExpired) {
+			session = ""
+		} else if err != nil || done {
+			return err
+		}
+	}
+	if session == "" {
+		var err error
+		if session, err = d.startUpload(ctx, name, size); err != nil {
+			return err
+		}
+		if err := started(session); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	for {
+		chunk := io.NewSectionReader(r, offset, min(d.chunkSize, size-offset))
+		next, done, err := d.sendChunk(ctx, session, chunk, offset, size)
+		if err != nil || done {
+			return err
+		}
+		// Drive keeps what it has received, so a chunk that doesn't move the
+		// offset on would be sent again forever
+		if next <= offset || next > size {
+			return fmt.Errorf("upload stuck at %d of %d bytes, Drive has received %d", offset, size, next)
+		}
+		offset = next
+	}
+}
+
+// startUpload starts a resumable upload of a new file, or a new version of
+// an existing one, and returns the URL of the upload session.
+func (d *Drive) startUpload(ctx context.Context, name string, size int64) (string, error) {
+	existing, err := d.find(ctx, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	method, url, metadata := http.MethodPost, d.uploadURL, map[string]any{"name": name, "parents": []string{d.folderID}}
+	if existing != nil {
+		method, url, metadata = http.MethodPatch, d.uploadURL+"/"+existing.Id, map[string]any{}
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url+"?uploadType=resumable", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return "", err
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("no upload session URL in response")
+	}
+	return session, nil
+}
+
+// sendChunk sends the chunk starting at offset to the upload session and
+// returns the offset Drive has received up to and whether the upload is
+// complete. A nil chunk only queries the offset.
+func (d *Drive) sendChunk(ctx context.Context, session string, chunk *io.SectionReader, offset, size int64) (int64, bool, error) {
+	var body io.Reader
+	contentRange := fmt.Sprintf("bytes */%d", size)
+	if chunk != nil {
+		body = chunk
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+chunk.Size()-1, size)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, body)
+	if err != nil {
+		return 0, false, err
+	}
+	if chunk != nil {
+		req.ContentLength = chunk.Size()
+	}
+	req.Header.Set("Content-Range", contentRange)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return size, true, nil
+	case http.StatusPermanentRedirect:
+		// Resume Incomplete, the Range header tells how much Drive has, eg.
+		// "bytes=0-1048575", or there's none if it has nothing
+		received := resp.Header.Get("Range")
+		if received == "" {
+			return 0, false, nil
+		}
+		_, last, _ := strings.Cut(received, "-")
+		end, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid range in response: %q", received)
+		}
+		return end + 1, false, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, false, errUploadExpired
+	default:
+		return 0, false, googleapi.CheckResponse(resp)
+	}
+}
+
+func (d *Drive) Download(ctx context.Context, name string, w io.Writer) error {
+	file, err := d.find(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.srv.Files.Get(file.Id).Context(ctx).Download()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (d *Drive) Delete(ctx context.Context, name string) error {
+	file, err := d.find(ctx, name)
+	if err != nil {
+		return err
+	}
+	return d.srv.Files.Delete(file.Id).Context(ctx).Do()
+}
+
+func (d *Drive) Stat(ctx context.Context, name string) (File, error) {
+	file, err := d.find(ctx, name)
+	if err != nil {
+		return File{}, err
+	}
+	return driveFile(file), nil
+}
+
+func (d *Drive) String() string {
+	return "Drive folder " + d.folderID
+}
+
+func (d *Drive) Close() error {
+	return nil
+}
+
+// find returns the newest file with the name. If there's none the error wraps
+// fs.ErrNotExist.
+func (d *Drive) find(ctx context.Context, name string) (*drive.File, error) {
+	// Backslashes and quotes are escaped with a backslash in queries
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name)
+	files, err := d.list(ctx, fmt.Sprintf(" and name = '%s'", escaped))
+	if err != nil {
+		return nil, err
+	}
+
+	var newest *drive.File
+	for _, f := range files {
+		if newest == nil || f.ModifiedTime > newest.ModifiedTime {
+			newest = f
+		}
+	}
+	if newest == nil {
+		return nil, notExist(name)
+	}
+	return newest, nil
+}
+
+// list returns the files in the folder that match the query, which is
+// appended to the folder condition.
+func (d *Drive) list(ctx context.Context, query string) ([]*drive.File, error) {
+	var files []*drive.File
+	q := d.srv.Files.List().
+		Q(fmt.Sprintf("'%s' in parents and trashed = false%s", d.folderID, query)).
+		Fields("nextPageToken", "files("+driveFileFields+")")
+	err := q.Pages(ctx, func(r *drive.FileList) error {
+		files = append(files, r.Files...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing files in Drive: %w", err)
+	}
+	return files, nil
+}
+
+func driveFile(f *drive.File) File {
+	// The times are in RFC 3339 format
+	modTime, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+	return File{Name: f.Name, Size: f.Size, ModTime: modTime, MD5: f.Md5Checksum}
+}
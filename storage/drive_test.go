@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// fakeDrive serves the parts of the Drive API that the backend uses: listing
+// and downloading files and the resumable upload protocol.
+type fakeDrive struct {
+	url string
+
+	mu       sync.Mutex
+	files    map[string]*fakeDriveFile
+	sessions map[string]*fakeDriveSession
+	nextID   int
+	// Fail the chunk that crosses this offset with a server error, after
+	// keeping the data up to it
+	interruptAt int64
+	// Answer chunks without keeping their data
+	stuck bool
+}
+
+type fakeDriveFile struct {
+	id       string
+	name     string
+	data     []byte
+	modified time.Time
+}
+
+type fakeDriveSession struct {
+	// Empty for a new file
+	fileID string
+	name   string
+	size   int64
+	data   []byte
+}
+
+var fakeDriveNameQuery = regexp.MustCompile(`name = '((?:[^'\\]|\\.)*)'`)
+
+func newFakeDrive(t *testing.T) (*fakeDrive, *Drive) {
+	f := &fakeDrive{files: make(map[string]*fakeDriveFile), sessions: make(map[string]*fakeDriveSession)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/v3/files", f.list)
+	mux.HandleFunc("GET /drive/v3/files/{id}", f.download)
+	mux.HandleFunc("POST /upload/drive/v3/files", f.startUpload)
+	mux.HandleFunc("PATCH /upload/drive/v3/files/{id}", f.startUpload)
+	mux.HandleFunc("PUT /upload/sessions/{id}", f.upload)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+
+	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL+"/drive/v3/"))
+	if err != nil {
+		t.Fatalf("Error creating Drive client: %v", err)
+	}
+	d := &Drive{
+		client:    server.Client(),
+		srv:       srv,
+		folderID:  "folder",
+		uploadURL: server.URL + "/upload/drive/v3/files",
+		chunkSize: 16 * 1024,
+	}
+	return f, d
+}
+
+func (f *fakeDrive) list(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var name string
+	if match := fakeDriveNameQuery.FindStringSubmatch(req.FormValue("q")); match != nil {
+		name = strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(match[1])
+	}
+	list := &drive.FileList{Files: []*drive.File{}}
+	for _, file := range f.files {
+		if name == "" || file.name == name {
+			md5, _ := Checksum(bytes.NewReader(file.data))
+			list.Files = append(list.Files, &drive.File{
+				Id:           file.id,
+				Name:         file.name,
+				Size:         int64(len(file.data)),
+				ModifiedTime: file.modified.Format(time.RFC3339Nano),
+				Md5Checksum:  md5,
+			})
+		}
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func (f *fakeDrive) download(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok := f.files[req.PathValue("id")]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Write(file.data)
+}
+
+func (f *fakeDrive) startUpload(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var metadata struct {
+		Name string `json:"name"`
+	}
+	var size int64
+	if err := json.NewDecoder(req.Body).Decode(&metadata); err != nil || req.FormValue("uploadType") != "resumable" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	fmt.Sscan(req.Header.Get("X-Upload-Content-Length"), &size)
+
+	session := &fakeDriveSession{name: metadata.Name, size: size}
+	if id := req.PathValue("id"); id != "" {
+		file, ok := f.files[id]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		session.fileID, session.name = id, file.name
+	}
+	f.nextID++
+	id := fmt.Sprint(f.nextID)
+	f.sessions[id] = session
+	w.Header().Set("Location", f.url+"/upload/sessions/"+id)
+}
+
+func (f *fakeDrive) upload(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[req.PathValue("id")]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	var data bytes.Buffer
+	data.ReadFrom(req.Body)
+	var start, end, size int64
+	contentRange := req.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err == nil {
+		if start != int64(len(session.data)) || end-start+1 != int64(data.Len()) || size != session.size {
+			http.Error(w, "unexpected range "+contentRange, http.StatusBadRequest)
+			return
+		}
+		received := data.Bytes()
+		if f.interruptAt > start && f.interruptAt <= end {
+			session.data = append(session.data, received[:f.interruptAt-start]...)
+			f.interruptAt = 0
+			http.Error(w, "interrupted", http.StatusServiceUnavailable)
+			return
+		}
+		if !f.stuck {
+			session.data = append(session.data, received...)
+		}
+	} else if _, err := fmt.Sscanf(contentRange, "bytes */%d", &size); err != nil || size != session.size {
+		http.Error(w, "unexpected range "+contentRange, http.StatusBadRequest)
+		return
+	}
+
+	if int64(len(session.data)) < session.size {
+		if len(session.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+
+	file, ok := f.files[session.fileID]
+	if !ok {
+		f.nextID++
+		file = &fakeDriveFile{id: fmt.Sprint("file", f.nextID), name: session.name}
+		f.files[file.id] = file
+		session.fileID = file.id
+	}
+	file.data = session.data
+	file.modified = time.Now()
+	w.WriteHeader(http.StatusOK)
+}
+
+// expireSessions forgets the upload sessions, as Drive does after a week.
+func (f *fakeDrive) expireSessions() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	clear(f.sessions)
+}
+
+func TestDriveResume(t *testing.T) {
+	f, d := newFakeDrive(t)
+	ctx := context.Background()
+	content := strings.Repeat("2024-07-15T13:09:48.683+0000\t$IIVHW,,,117,M,05.7,N,,*61\n", 2000)
+	size := int64(len(content))
+
+	var session string
+	started := func(s string) error {
+		session = s
+		return nil
+	}
+	f.interruptAt = size / 2
+	err := d.ResumeUpload(ctx, "nmea-1.log", strings.NewReader(content), size, "", started)
+	if !IsTransient(err) {
+		t.Fatalf("Expected a transient error from the interrupted upload, got %v", err)
+	}
+	if session == "" {
+		t.Fatalf("Expected the upload session to be started")
+	}
+	if _, err := d.Stat(ctx, "nmea-1.log"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected no file from the interrupted upload, got %v", err)
+	}
+
+	// Reads from before where the upload stopped fail, to check that they're
+	// not sent again
+	resumed := session
+	err = d.ResumeUpload(ctx, "nmea-1.log", &skippingReader{r: strings.NewReader(content), from: size / 2}, size, session, started)
+	if err != nil {
+		t.Fatalf("Error resuming the upload: %v", err)
+	}
+	if session != resumed {
+		t.Errorf("Expected the upload to continue in session %q, got %q", resumed, session)
+	}
+
+	var downloaded bytes.Buffer
+	if err := d.Download(ctx, "nmea-1.log", &downloaded); err != nil {
+		t.Fatalf("Error downloading: %v", err)
+	}
+	if downloaded.String() != content {
+		t.Errorf("Expected the resumed upload to have the content, got %d bytes", downloaded.Len())
+	}
+
+	// Resuming a finished upload only checks that it's complete
+	if err := d.ResumeUpload(ctx, "nmea-1.log", &skippingReader{r: strings.NewReader(content), from: size}, size, session, started); err != nil {
+		t.Errorf("Error resuming a finished upload: %v", err)
+	}
+
+	// An expired session starts over, as a new version of the existing file
+	f.interruptAt = size / 2
+	d.ResumeUpload(ctx, "nmea-1.log", strings.NewReader(content+content), 2*size, "", started)
+	f.expireSessions()
+	expired := session
+	if err := d.ResumeUpload(ctx, "nmea-1.log", strings.NewReader(content+content), 2*size, session, started); err != nil {
+		t.Fatalf("Error resuming an expired upload: %v", err)
+	}
+	if session == expired {
+		t.Errorf("Expected a new session for the expired upload")
+	}
+	files, err := d.List(ctx)
+	if err != nil || len(files) != 1 || files[0].Size != 2*size {
+		t.Errorf("Expected the existing file to be replaced, got %+v, %v", files, err)
+	}
+}
+
+func TestDriveResumeStuck(t *testing.T) {
+	f, d := newFakeDrive(t)
+	f.stuck = true
+
+	content := strings.Repeat("x", 100*1024)
+	done := make(chan error)
+	go func() {
+		done <- d.ResumeUpload(context.Background(), "nmea-1.log", strings.NewReader(content), int64(len(content)), "", func(string) error { return nil })
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected an error for an upload that makes no progress")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Upload that makes no progress didn't stop")
+	}
+}
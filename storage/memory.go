@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory keeps the files in memory. It stands in for a real backend in tests.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]memoryFile
+	// The data received so far of unfinished uploads, by session
+	partial map[string][]byte
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string]memoryFile), partial: make(map[string][]byte)}
+}
+
+// List returns the files sorted by name.
+func (m *Memory) List(ctx context.Context) ([]File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]File, 0, len(m.files))
+	for name, file := range m.files {
+		files = append(files, File{Name: name, Size: int64(len(file.data)), ModTime: file.modTime})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func (m *Memory) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, len(data))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = memoryFile{data: data, modTime: time.Now()}
+	return nil
+}
+
+// ResumeUpload keeps the data received before an error for resuming the
+// upload. The session is the name of the file.
+func (m *Memory) ResumeUpload(ctx context.Context, name string, r io.ReaderAt, size int64, session string, started func(session string) error) error {
+	m.mu.Lock()
+	data, ok := m.partial[session]
+	m.mu.Unlock()
+	if !ok || int64(len(data)) > size {
+		session = name
+		data = nil
+		if err := started(session); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	for offset := int64(len(data)); offset < size; {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-offset)], offset)
+		data = append(data, buf[:n]...)
+		offset += int64(n)
+		if err != nil && offset < size {
+			m.mu.Lock()
+			m.partial[session] = data
+			m.mu.Unlock()
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.partial, session)
+	m.files[name] = memoryFile{data: data, modTime: time.Now()}
+	return nil
+}
+
+func (m *Memory) Download(ctx context.Context, name string, w io.Writer) error {
+	file, err := m.file(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(file.data)
+	return err
+}
+
+func (m *Memory) Delete(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return notExist(name)
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *Memory) Stat(ctx context.Context, name string) (File, error) {
+	file, err := m.file(name)
+	if err != nil {
+		return File{}, err
+	}
+	md5, err := Checksum(bytes.NewReader(file.data))
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, Size: int64(len(file.data)), ModTime: file.modTime, MD5: md5}, nil
+}
+
+func (m *Memory) String() string {
+	return "memory"
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) file(name string) (memoryFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, ok := m.files[name]
+	if !ok {
+		return memoryFile{}, notExist(name)
+	}
+	return file, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/sftp"
+	"github.com/studio-b12/gowebdav"
+	"google.golang.org/api/googleapi"
+)
+
+// Resumable is implemented by the backends that can continue an interrupted
+// upload from where it stopped, also in a later run of the program.
+type Resumable interface {
+	// ResumeUpload uploads the file like Upload. session identifies an upload
+	// that was started earlier, an empty session starts a new one. The
+	// backend calls started with the session of a new upload before sending
+	// any data, so that it can be persisted. Uploads the backend no longer
+	// knows about are started over.
+	ResumeUpload(ctx context.Context, name string, r io.ReaderAt, size int64, session string, started func(session string) error) error
+}
+
+// IsTransient reports whether the error is likely to go away by retrying,
+// such as a dropped connection or a server error.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// Any network error, but not file system errors, which are net.Errors
+	// too as syscall.Errno implements the interface
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, target := range []error{io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE, sftp.ErrSSHFxConnectionLost} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return transientStatus(googleErr.Code)
+	}
+	var webdavErr gowebdav.StatusError
+	if errors.As(err, &webdavErr) {
+		return transientStatus(webdavErr.Status)
+	}
+	var s3Err minio.ErrorResponse
+	if errors.As(err, &s3Err) {
+		return transientStatus(s3Err.StatusCode)
+	}
+	return false
+}
+
+func transientStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// partialFile is an unfinished upload in a file system, local or SFTP.
+type partialFile interface {
+	io.Writer
+	io.Seeker
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// writeRest writes r to the partial upload in file. With resume it continues
+// after the data already in the file, unless there's more of it than r has.
+func writeRest(file partialFile, r io.ReaderAt, size int64, resume bool) error {
+	var offset int64
+	if resume {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		offset = info.Size()
+	}
+	if offset > size {
+		offset = 0
+	}
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(file, io.NewSectionReader(r, offset, size-offset))
+	if err == nil && offset+n != size {
+		err = fmt.Errorf("expected %d bytes, got %d", size, offset+n)
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// S3PartSize is the size of the parts of resumable uploads. It's the minimum
+// S3 allows, smaller files are uploaded in one request.
+const S3PartSize = 5 * 1024 * 1024
+
+// S3 stores the files in an S3 bucket, under a common prefix.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	secure bool
+}
+
+func NewS3(c *nmealogger.S3Config) (*S3, error) {
+	creds := credentials.NewEnvAWS()
+	if c.AccessKey != "" {
+		creds = credentials.NewStaticV4(c.AccessKey, c.SecretKey, "")
+	}
+	client, err := minio.New(c.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !c.Insecure,
+		Region: c.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 client: %w", err)
+	}
+	return &S3{client: client, bucket: c.Bucket, prefix: c.Prefix, secure: !c.Insecure}, nil
+}
+
+// List returns the objects directly under the prefix.
+func (s *S3) List(ctx context.Context) ([]File, error) {
+	var files []File
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("error listing objects in S3: %w", object.Err)
+		}
+		name := strings.TrimPrefix(object.Key, s.prefix)
+		// Skip the "directories" when the prefix doesn't end with a slash
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		files = append(files, File{Name: name, Size: object.Size, ModTime: object.LastModified})
+	}
+	return files, nil
+}
+
+// Upload sends the file with its MD5 checksum, which S3 verifies. Files
+// smaller than the multipart threshold of 16 MiB are uploaded in one part so
+// that the ETag is the MD5 checksum.
+func (s *S3) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+name, r, size, minio.PutObjectOptions{
+		SendContentMd5: true,
+	})
+	return err
+}
+
+// ResumeUpload uploads files larger than S3PartSize as a multipart upload,
+// the session is the upload ID. The parts that were uploaded before are
+// skipped.
+func (s *S3) ResumeUpload(ctx context.Context, name string, r io.ReaderAt, size int64, session string, started func(session string) error) error {
+	if size <= S3PartSize {
+		return s.Upload(ctx, name, io.NewSectionReader(r, 0, size), size)
+	}
+
+	core := minio.Core{Client: s.client}
+	key := s.prefix + name
+	var parts []minio.CompletePart
+	if session != "" {
+		var err error
+		parts, err = s.uploadedParts(ctx, core, key, session)
+		if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+			session = ""
+		} else if err != nil {
+			return err
+		}
+	}
+	if session == "" {
+		var err error
+		if session, err = core.NewMultipartUpload(ctx, s.bucket, key, minio.PutObjectOptions{}); err != nil {
+			return err
+		}
+		if err := started(session); err != nil {
+			return err
+		}
+		parts = nil
+	}
+
+	for offset := int64(len(parts)) * S3PartSize; offset < size; offset += S3PartSize {
+		partSize := min(S3PartSize, size-offset)
+		part, err := core.PutObjectPart(ctx, s.bucket, key, session, len(parts)+1,
+			io.NewSectionReader(r, offset, partSize), partSize, minio.PutObjectPartOptions{})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	_, err := core.CompleteMultipartUpload(ctx, s.bucket, key, session, parts, minio.PutObjectOptions{})
+	return err
+}
+
+// uploadedParts returns the parts of the upload from the first one up to the
+// first one that is missing.
+func (s *S3) uploadedParts(ctx context.Context, core minio.Core, key, uploadID string) ([]minio.CompletePart, error) {
+	etags := make(map[int]string)
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, s.bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.ObjectParts {
+			etags[part.PartNumber] = part.ETag
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	var parts []minio.CompletePart
+	for number := 1; etags[number] != ""; number++ {
+		parts = append(parts, minio.CompletePart{PartNumber: number, ETag: etags[number]})
+	}
+	return parts, nil
+}
+
+func (s *S3) Download(ctx context.Context, name string, w io.Writer) error {
+	object, err := s.client.GetObject(ctx, s.bucket, s.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return s.error(name, err)
+	}
+	defer object.Close()
+
+	_, err = io.Copy(w, object)
+	return s.error(name, err)
+}
+
+// Delete removes the object. S3 doesn't report deleting a missing object as
+// an error, so it's checked first.
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if _, err := s.client.StatObject(ctx, s.bucket, s.prefix+name, minio.StatObjectOptions{}); err != nil {
+		return s.error(name, err)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+// Stat uses the ETag as the checksum. The ETags of multipart uploads aren't
+// checksums of the content, those objects are downloaded to calculate it.
+func (s *S3) Stat(ctx context.Context, name string) (File, error) {
+	object, err := s.client.StatObject(ctx, s.bucket, s.prefix+name, minio.StatObjectOptions{})
+	if err != nil {
+		return File{}, s.error(name, err)
+	}
+
+	file := File{Name: name, Size: object.Size, ModTime: object.LastModified, MD5: object.ETag}
+	if len(file.MD5) != 32 || strings.Contains(file.MD5, "-") {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(s.Download(ctx, name, writer))
+		}()
+		file.MD5, err = Checksum(reader)
+		reader.Close()
+		if err != nil {
+			return File{}, err
+		}
+	}
+	return file, nil
+}
+
+func (s *S3) String() string {
+	scheme := "https"
+	if !s.secure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", scheme, s.client.EndpointURL().Host, s.bucket, s.prefix)
+}
+
+func (s *S3) Close() error {
+	return nil
+}
+
+// error wraps fs.ErrNotExist for missing objects.
+func (s *S3) error(name string, err error) error {
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return notExist(name)
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// SFTP stores the files in a directory on an SSH server. The client doesn't
+// support contexts, the requests run to completion. A lost connection is
+// reconnected on the next request.
+type SFTP struct {
+	dial   func() (*ssh.Client, *sftp.Client, error)
+	conn   *ssh.Client
+	client *sftp.Client
+	dir    string
+	name   string
+}
+
+func NewSFTP(c *nmealogger.SFTPConfig) (*SFTP, error) {
+	hostKeyCallback, err := knownhosts.New(c.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts: %w", err)
+	}
+	auth := ssh.Password(c.Password)
+	if c.KeyFile != "" {
+		key, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing SSH key: %w", err)
+		}
+		auth = ssh.PublicKeys(signer)
+	}
+
+	addr := c.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	dial := func() (*ssh.Client, *sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            c.User,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeyCallback,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("error starting SFTP: %w", err)
+		}
+		return conn, client, nil
+	}
+
+	conn, client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	s := newSFTP(client, c.Dir, fmt.Sprintf("sftp://%s@%s/%s", c.User, addr, c.Dir))
+	s.dial = dial
+	s.conn = conn
+	return s, nil
+}
+
+func newSFTP(client *sftp.Client, dir string, name string) *SFTP {
+	if dir == "" {
+		dir = "."
+	}
+	return &SFTP{client: client, dir: dir, name: name}
+}
+
+func (s *SFTP) List(ctx context.Context) ([]File, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := client.ReadDir(s.dir)
+	if err != nil {
+		return nil, s.check(err)
+	}
+
+	var files []File
+	for _, info := range infos {
+		// Skip directories and unfinished uploads
+		if !info.Mode().IsRegular() || path.Ext(info.Name()) == ".tmp" {
+			continue
+		}
+		files = append(files, File{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// Upload writes the file under a temporary name and renames it when complete,
+// so that an interrupted upload doesn't leave a partial file.
+func (s *SFTP) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	tmpName := s.path(name) + ".tmp"
+	file, err := client.Create(tmpName)
+	if err != nil {
+		return s.check(err)
+	}
+
+	n, err := file.ReadFrom(r)
+	if err == nil && n != size {
+		err = fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = client.PosixRename(tmpName, s.path(name))
+	}
+	if err != nil {
+		client.Remove(tmpName)
+	}
+	return s.check(err)
+}
+
+// ResumeUpload writes the file to name.tmp, which is the session, and renames
+// it when complete. A resumed upload appends to the file.
+func (s *SFTP) ResumeUpload(ctx context.Context, name string, r io.ReaderAt, size int64, session string, started func(session string) error) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	tmpName := s.path(name) + ".tmp"
+	resume := session != ""
+	if !resume {
+		if err := started(path.Base(tmpName)); err != nil {
+			return err
+		}
+	}
+
+	file, err := client.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return s.check(err)
+	}
+	err = writeRest(file, r, size, resume)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = client.PosixRename(tmpName, s.path(name))
+	}
+	return s.check(err)
+}
+
+func (s *SFTP) Download(ctx context.Context, name string, w io.Writer) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	file, err := client.Open(s.path(name))
+	if err != nil {
+		return s.check(err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteTo(w)
+	return s.check(err)
+}
+
+func (s *SFTP) Delete(ctx context.Context, name string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	return s.check(client.Remove(s.path(name)))
+}
+
+// Stat reads the file to calculate the checksum, SFTP has no request for it.
+func (s *SFTP) Stat(ctx context.Context, name string) (File, error) {
+	client, err := s.connect()
+	if err != nil {
+		return File{}, err
+	}
+	file, err := client.Open(s.path(name))
+	if err != nil {
+		return File{}, s.check(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return File{}, s.check(err)
+	}
+	md5, err := Checksum(file)
+	if err != nil {
+		return File{}, s.check(err)
+	}
+	return File{Name: name, Size: info.Size(), ModTime: info.ModTime(), MD5: md5}, nil
+}
+
+func (s *SFTP) String() string {
+	return s.name
+}
+
+func (s *SFTP) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return err
+}
+
+// connect returns the client, reconnecting if the connection was lost.
+func (s *SFTP) connect() (*sftp.Client, error) {
+	if s.client != nil {
+		return s.client, nil
+	}
+	if s.dial == nil {
+		return nil, sftp.ErrSSHFxConnectionLost
+	}
+
+	conn, client, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	s.client = client
+	return client, nil
+}
+
+// check drops the client if the error is a lost connection, so that the next
+// request reconnects.
+func (s *SFTP) check(err error) error {
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		s.Close()
+		s.client = nil
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *SFTP) path(name string) string {
+	return path.Join(s.dir, path.Base(name))
+}
@@ -0,0 +1,114 @@
+// Package storage keeps the log files in a remote store for logupload and
+// logdownload. The backends are Google Drive, S3-compatible object stores,
+// WebDAV, SFTP and a plain directory, which can be a mounted network share.
+//
+// The files are in one flat folder and are identified by their names.
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// File describes a stored file.
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Hex encoded MD5 checksum of the content
+	MD5 string
+}
+
+// Storage is a folder of files in a backend. Names are base names, without
+// directories. The errors for missing files wrap fs.ErrNotExist.
+type Storage interface {
+	// List returns the files in the folder.
+	List(ctx context.Context) ([]File, error)
+	// Upload stores the size bytes read from r as name, replacing an existing
+	// file.
+	Upload(ctx context.Context, name string, r io.Reader, size int64) error
+	// Download writes the content of the file to w.
+	Download(ctx context.Context, name string, w io.Writer) error
+	Delete(ctx context.Context, name string) error
+	// Stat returns the file with its checksum. Backends that don't keep
+	// checksums read the file to calculate it.
+	Stat(ctx context.Context, name string) (File, error)
+	// String describes the folder for logging, eg. s3://bucket/prefix
+	String() string
+	Close() error
+}
+
+// New returns the backend selected in the config, which has already been
+// validated with ValidateStorage.
+func New(ctx context.Context, cfg *nmealogger.Config) (Storage, error) {
+	switch cfg.Storage.Backend {
+	case "drive":
+		return NewDrive(ctx, &cfg.Drive)
+	case "s3":
+		return NewS3(&cfg.Storage.S3)
+	case "webdav":
+		return NewWebDAV(&cfg.Storage.WebDAV), nil
+	case "sftp":
+		return NewSFTP(&cfg.Storage.SFTP)
+	case "dir":
+		return NewDir(cfg.Storage.Dir), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// ErrChecksumMismatch is returned by Verify if the stored file differs from
+// the local one.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum returns the hex encoded MD5 checksum of the content read from r.
+func Checksum(r io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Verify checks that the stored file has the hex encoded MD5 checksum. The
+// error wraps ErrChecksumMismatch if it doesn't.
+func Verify(ctx context.Context, s Storage, name string, md5 string) error {
+	file, err := s.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(file.MD5, md5) {
+		return fmt.Errorf("%s: %w, local %s, stored %s", name, ErrChecksumMismatch, md5, file.MD5)
+	}
+	return nil
+}
+
+func notExist(name string) error {
+	return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
+
+// sizeReader returns an error instead of EOF if the reader doesn't have the
+// expected size, for backends that would store a truncated file otherwise.
+type sizeReader struct {
+	r    io.Reader
+	size int64
+	n    int64
+}
+
+func (r *sizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err == io.EOF && r.n != r.size {
+		err = fmt.Errorf("expected %d bytes, got %d", r.size, r.n)
+	}
+	return n, err
+}
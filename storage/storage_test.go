@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/sftp"
+	"golang.org/x/net/webdav"
+	"google.golang.org/api/googleapi"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// testStorage runs the same checks against each backend.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	content := "2024-07-15T13:09:48.683+0000\t$IIVHW,,,117,M,05.7,N,,*61\n"
+	upload := func(name, content string) {
+		t.Helper()
+		if err := s.Upload(ctx, name, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Error uploading %s: %v", name, err)
+		}
+	}
+	upload("nmea-1.log", content)
+	upload("nmea-2.log", "replaced")
+	// Uploading again replaces the file
+	upload("nmea-2.log", content+content)
+
+	files, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("Error listing files: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, file := range files {
+		sizes[file.Name] = file.Size
+	}
+	if len(files) != 2 || sizes["nmea-1.log"] != int64(len(content)) || sizes["nmea-2.log"] != int64(2*len(content)) {
+		t.Errorf("Expected nmea-1.log and nmea-2.log, got %+v", files)
+	}
+
+	var downloaded bytes.Buffer
+	if err := s.Download(ctx, "nmea-1.log", &downloaded); err != nil {
+		t.Fatalf("Error downloading: %v", err)
+	}
+	if downloaded.String() != content {
+		t.Errorf("Expected %q, got %q", content, downloaded.String())
+	}
+
+	file, err := s.Stat(ctx, "nmea-1.log")
+	if err != nil {
+		t.Fatalf("Error getting file info: %v", err)
+	}
+	// md5sum of the content
+	if file.MD5 != "0c6668615cc9f3a6d9d56a350590f6ee" || file.Size != int64(len(content)) {
+		t.Errorf("Unexpected file info %+v", file)
+	}
+
+	if err := Verify(ctx, s, "nmea-1.log", "0C6668615CC9F3A6D9D56A350590F6EE"); err != nil {
+		t.Errorf("Expected the checksum to match, got %v", err)
+	}
+	if err := Verify(ctx, s, "nmea-2.log", file.MD5); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+
+	// The size is checked to catch truncated reads
+	if err := s.Upload(ctx, "nmea-3.log", strings.NewReader(content), 1000); err == nil {
+		t.Errorf("Expected an error for a short upload")
+	}
+
+	if err := s.Delete(ctx, "nmea-1.log"); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+	if _, err := s.Stat(ctx, "nmea-1.log"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not exist error for a deleted file, got %v", err)
+	}
+	if err := s.Delete(ctx, "nmea-1.log"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not exist error deleting a missing file, got %v", err)
+	}
+	if err := s.Download(ctx, "nmea-1.log", io.Discard); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not exist error downloading a missing file, got %v", err)
+	}
+
+	files, err = s.List(ctx)
+	if err != nil {
+		t.Fatalf("Error listing files: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "nmea-2.log" {
+		t.Errorf("Expected only nmea-2.log to be left, got %+v", files)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Error closing: %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
+
+func TestDir(t *testing.T) {
+	testStorage(t, NewDir(t.TempDir()))
+}
+
+func TestWebDAV(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+
+	testStorage(t, NewWebDAV(&nmealogger.WebDAVConfig{URL: server.URL}))
+}
+
+func TestSFTP(t *testing.T) {
+	testStorage(t, newTestSFTP(t))
+}
+
+// newTestSFTP returns a client for an in-memory SFTP server.
+func newTestSFTP(t *testing.T) *SFTP {
+	clientConn, serverConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatalf("Error starting SFTP client: %v", err)
+	}
+	return newSFTP(client, "/", "sftp")
+}
+
+// failingReader fails reads past limit, like a file upload cut off by a
+// dropped connection.
+type failingReader struct {
+	r     io.ReaderAt
+	limit int64
+}
+
+func (r *failingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.limit {
+		if off >= r.limit {
+			return 0, io.ErrUnexpectedEOF
+		}
+		n, _ := r.r.ReadAt(p[:r.limit-off], off)
+		return n, io.ErrUnexpectedEOF
+	}
+	return r.r.ReadAt(p, off)
+}
+
+// testResume interrupts an upload and checks that it's completed from the
+// session.
+func testResume(t *testing.T, s Resumable, store Storage) {
+	ctx := context.Background()
+	content := strings.Repeat("2024-07-15T13:09:48.683+0000\t$IIVHW,,,117,M,05.7,N,,*61\n", 2000)
+	size := int64(len(content))
+
+	var session string
+	started := func(s string) error {
+		session = s
+		return nil
+	}
+	err := s.ResumeUpload(ctx, "nmea-1.log", &failingReader{r: strings.NewReader(content), limit: size / 2}, size, "", started)
+	if !IsTransient(err) {
+		t.Fatalf("Expected a transient error from the interrupted upload, got %v", err)
+	}
+	if session == "" {
+		t.Fatalf("Expected the upload session to be started")
+	}
+	if _, err := store.Stat(ctx, "nmea-1.log"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected no file from the interrupted upload, got %v", err)
+	}
+
+	// Reads from before where the upload stopped fail, to check that they're
+	// not sent again
+	resumed := session
+	err = s.ResumeUpload(ctx, "nmea-1.log", &skippingReader{r: strings.NewReader(content), from: size / 2}, size, session, started)
+	if err != nil {
+		t.Fatalf("Error resuming the upload: %v", err)
+	}
+	if session != resumed {
+		t.Errorf("Expected the upload to continue in session %q, got %q", resumed, session)
+	}
+
+	var downloaded bytes.Buffer
+	if err := store.Download(ctx, "nmea-1.log", &downloaded); err != nil {
+		t.Fatalf("Error downloading: %v", err)
+	}
+	if downloaded.String() != content {
+		t.Errorf("Expected the resumed upload to have the content, got %d bytes", downloaded.Len())
+	}
+	files, err := store.List(ctx)
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected only the uploaded file, got %+v, %v", files, err)
+	}
+
+	// An unknown session starts over
+	session = ""
+	if err := s.ResumeUpload(ctx, "nmea-2.log", strings.NewReader(content), size, "unknown", started); err != nil {
+		t.Fatalf("Error uploading with an unknown session: %v", err)
+	}
+	downloaded.Reset()
+	if err := store.Download(ctx, "nmea-2.log", &downloaded); err != nil || downloaded.String() != content {
+		t.Errorf("Expected the upload with an unknown session to have the content, got %d bytes, %v", downloaded.Len(), err)
+	}
+}
+
+type skippingReader struct {
+	r    io.ReaderAt
+	from int64
+}
+
+func (r *skippingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.from {
+		return 0, errors.New("read from the uploaded part")
+	}
+	return r.r.ReadAt(p, off)
+}
+
+func TestMemoryResume(t *testing.T) {
+	s := NewMemory()
+	testResume(t, s, s)
+}
+
+func TestDirResume(t *testing.T) {
+	s := NewDir(t.TempDir())
+	testResume(t, s, s)
+}
+
+func TestSFTPResume(t *testing.T) {
+	s := newTestSFTP(t)
+	testResume(t, s, s)
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("permission denied"), false},
+		{&fs.PathError{Op: "open", Path: "nmea-1.log", Err: syscall.ENOENT}, false},
+		{fmt.Errorf("uploading: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&googleapi.Error{Code: 503}, true},
+		{&googleapi.Error{Code: 403}, false},
+		{minio.ErrorResponse{StatusCode: 500, Code: "InternalError"}, true},
+		{minio.ErrorResponse{StatusCode: 404, Code: "NoSuchKey"}, false},
+		{context.Canceled, false},
+	} {
+		if transient := IsTransient(tc.err); transient != tc.transient {
+			t.Errorf("Expected IsTransient(%v) to be %v", tc.err, tc.transient)
+		}
+	}
+}
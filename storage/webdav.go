@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/studio-b12/gowebdav"
+
+	nmealogger "github.com/mpihlak/go-nmealogger"
+)
+
+// WebDAV stores the files in a WebDAV collection, eg. a Nextcloud folder.
+// The client doesn't support contexts, the requests run to completion.
+type WebDAV struct {
+	client *gowebdav.Client
+	url    string
+}
+
+func NewWebDAV(c *nmealogger.WebDAVConfig) *WebDAV {
+	return &WebDAV{client: gowebdav.NewClient(c.URL, c.User, c.Password), url: c.URL}
+}
+
+func (d *WebDAV) List(ctx context.Context) ([]File, error) {
+	infos, err := d.client.ReadDir("/")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, info := range infos {
+		// Skip collections and unfinished uploads
+		if info.IsDir() || path.Ext(info.Name()) == ".tmp" {
+			continue
+		}
+		files = append(files, File{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// Upload writes the file under a temporary name and moves it in place when
+// complete, servers may keep what they got of an interrupted upload.
+func (d *WebDAV) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	tmpName := name + ".tmp"
+	err := d.client.WriteStream(tmpName, &sizeReader{r: r, size: size}, 0)
+	if err == nil {
+		err = d.client.Rename(tmpName, name, true)
+	}
+	if err != nil {
+		d.client.Remove(tmpName)
+	}
+	return err
+}
+
+func (d *WebDAV) Download(ctx context.Context, name string, w io.Writer) error {
+	stream, err := d.client.ReadStream(name)
+	if err != nil {
+		return d.error(name, err)
+	}
+	defer stream.Close()
+
+	_, err = io.Copy(w, stream)
+	return err
+}
+
+// Delete removes the file. The client doesn't report deleting a missing file
+// as an error, so it's checked first.
+func (d *WebDAV) Delete(ctx context.Context, name string) error {
+	if _, err := d.client.Stat(name); err != nil {
+		return d.error(name, err)
+	}
+	return d.client.Remove(name)
+}
+
+// Stat downloads the file to calculate the checksum, WebDAV has no standard
+// property for it.
+func (d *WebDAV) Stat(ctx context.Context, name string) (File, error) {
+	info, err := d.client.Stat(name)
+	if err != nil {
+		return File{}, d.error(name, err)
+	}
+
+	stream, err := d.client.ReadStream(name)
+	if err != nil {
+		return File{}, d.error(name, err)
+	}
+	defer stream.Close()
+	md5, err := Checksum(stream)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, Size: info.Size(), ModTime: info.ModTime(), MD5: md5}, nil
+}
+
+func (d *WebDAV) String() string {
+	return d.url
+}
+
+func (d *WebDAV) Close() error {
+	return nil
+}
+
+// error wraps fs.ErrNotExist for missing files.
+func (d *WebDAV) error(name string, err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		return notExist(name)
+	}
+	return err
+}
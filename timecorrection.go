@@ -0,0 +1,148 @@
+package nmealogger
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// A change of the offset between log and GPS time larger than this is
+	// considered a clock step, eg. NTP syncing, rather than drift or jitter.
+	ClockStepThreshold = 2 * time.Second
+	// Drift is only estimated over at least this much time, on shorter spans
+	// the resolution of GPS time dominates.
+	MinDriftSpan = 2 * time.Minute
+)
+
+var ErrNoGPSTime = errors.New("no sentences with GPS time")
+
+// ClockSegment describes the error of the logging clock over a range of log
+// entries during which the clock wasn't stepped.
+type ClockSegment struct {
+	// Index of the first log entry the segment applies to
+	FirstEntry int
+	// Log time of the first GPS time sample in the segment
+	Reference time.Time
+	// GPS time minus log time at the reference time
+	Offset time.Duration
+	// Change of the offset per second of log time, ie. the rate error of the clock
+	Drift float64
+	// Number of GPS time samples in the segment
+	Samples int
+}
+
+// TimeCorrection maps log timestamps to GPS time.
+type TimeCorrection struct {
+	Segments []ClockSegment
+}
+
+type clockSample struct {
+	entry    int
+	received time.Time
+	offset   time.Duration
+}
+
+// EstimateTimeCorrection derives the offset and drift between the log
+// timestamps and the GPS time contained in RMC and ZDA sentences. If the
+// clock was stepped while logging the entries are split into segments with
+// their own correction.
+func EstimateTimeCorrection(entries []LogEntry) (*TimeCorrection, error) {
+	var samples []clockSample
+	for i, entry := range entries {
+		if !HasValidChecksum(entry.Sentence) {
+			continue
+		}
+		if gpsTime, ok := ParseGPSTime(entry.Sentence); ok {
+			samples = append(samples, clockSample{
+				entry:    i,
+				received: entry.Time,
+				offset:   gpsTime.Sub(entry.Time),
+			})
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, ErrNoGPSTime
+	}
+
+	correction := &TimeCorrection{}
+	segmentStart := 0
+	for i := 1; i <= len(samples); i++ {
+		if i < len(samples) && (samples[i].offset-samples[i-1].offset).Abs() <= ClockStepThreshold {
+			continue
+		}
+
+		firstEntry := 0
+		if segmentStart > 0 {
+			firstEntry = findClockStep(entries, samples[segmentStart-1].entry, samples[segmentStart].entry)
+		}
+		segment := fitClockSegment(samples[segmentStart:i])
+		segment.FirstEntry = firstEntry
+		correction.Segments = append(correction.Segments, segment)
+
+		segmentStart = i
+	}
+
+	return correction, nil
+}
+
+// findClockStep returns the index of the entry after the largest jump in log
+// time between the entries from and to, which is where the clock was stepped.
+func findClockStep(entries []LogEntry, from, to int) int {
+	step := to
+	var largestJump time.Duration
+	for i := from + 1; i <= to; i++ {
+		if jump := entries[i].Time.Sub(entries[i-1].Time).Abs(); jump > largestJump {
+			largestJump = jump
+			step = i
+		}
+	}
+
+	return step
+}
+
+// fitClockSegment fits a line to the offsets with least squares.
+func fitClockSegment(samples []clockSample) ClockSegment {
+	reference := samples[0].received
+	span := samples[len(samples)-1].received.Sub(reference)
+
+	var sumX, sumY, sumXX, sumXY float64
+	for _, sample := range samples {
+		x := sample.received.Sub(reference).Seconds()
+		y := sample.offset.Seconds()
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+
+	n := float64(len(samples))
+	drift := 0.0
+	if span >= MinDriftSpan {
+		drift = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	}
+	offset := (sumY - drift*sumX) / n
+
+	return ClockSegment{
+		Reference: reference,
+		Offset:    time.Duration(offset * float64(time.Second)),
+		Drift:     drift,
+		Samples:   len(samples),
+	}
+}
+
+// Correct returns the GPS time for the log entry with the given index and
+// timestamp.
+func (tc *TimeCorrection) Correct(entry int, t time.Time) time.Time {
+	segment := tc.Segments[0]
+	for _, s := range tc.Segments[1:] {
+		if s.FirstEntry > entry {
+			break
+		}
+		segment = s
+	}
+
+	elapsed := t.Sub(segment.Reference).Seconds()
+	offset := segment.Offset + time.Duration(segment.Drift*elapsed*float64(time.Second))
+	return t.Add(offset)
+}
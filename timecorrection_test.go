@@ -0,0 +1,93 @@
+package nmealogger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// rmcSentence returns a valid RMC sentence for the given GPS time.
+func rmcSentence(t time.Time) string {
+	data := fmt.Sprintf("GPRMC,%s,A,5930.970,N,02446.315,E,05.7,160,%s,00,E,A",
+		t.Format("150405"), t.Format("020106"))
+	return "$" + data + "*" + CalculateChecksum(data)
+}
+
+func TestEstimateTimeCorrection(t *testing.T) {
+	gpsStart := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	bootTime := time.Date(1970, 1, 1, 0, 0, 10, 0, time.UTC)
+
+	// Clock starts at 1970 and is stepped to the correct time by NTP after 60s
+	var entries []LogEntry
+	for i := 0; i < 120; i++ {
+		gpsTime := gpsStart.Add(time.Duration(i) * time.Second)
+		logTime := bootTime.Add(time.Duration(i)*time.Second + 300*time.Millisecond)
+		if i >= 60 {
+			logTime = gpsTime.Add(300 * time.Millisecond)
+		}
+		entries = append(entries,
+			LogEntry{Time: logTime, Sentence: "$IIVLW,09390,N,000.0,N*50"},
+			LogEntry{Time: logTime.Add(10 * time.Millisecond), Sentence: rmcSentence(gpsTime)},
+		)
+	}
+
+	correction, err := EstimateTimeCorrection(entries)
+	if err != nil {
+		t.Fatalf("Error estimating correction: %v", err)
+	}
+	if len(correction.Segments) != 2 {
+		t.Fatalf("Expected 2 clock segments, got %+v", correction.Segments)
+	}
+	if correction.Segments[1].FirstEntry != 120 {
+		t.Fatalf("Expected clock step at entry 120, got %d", correction.Segments[1].FirstEntry)
+	}
+
+	for _, i := range []int{0, 1, 119, 120, 239} {
+		expected := gpsStart.Add(time.Duration(i/2) * time.Second)
+		corrected := correction.Correct(i, entries[i].Time)
+		if corrected.Sub(expected).Abs() > 500*time.Millisecond {
+			t.Errorf("Entry %d corrected to %v, expected about %v", i, corrected, expected)
+		}
+	}
+}
+
+func TestEstimateTimeCorrectionDrift(t *testing.T) {
+	gpsStart := time.Date(2024, 7, 15, 13, 0, 0, 0, time.UTC)
+	logStart := gpsStart.Add(-time.Hour)
+
+	// The logging clock runs 100ppm fast
+	var entries []LogEntry
+	for i := 0; i < 600; i++ {
+		gpsTime := gpsStart.Add(time.Duration(i) * time.Second)
+		elapsed := time.Duration(float64(i) * 1.0001 * float64(time.Second))
+		entries = append(entries, LogEntry{Time: logStart.Add(elapsed), Sentence: rmcSentence(gpsTime)})
+	}
+
+	correction, err := EstimateTimeCorrection(entries)
+	if err != nil {
+		t.Fatalf("Error estimating correction: %v", err)
+	}
+	if len(correction.Segments) != 1 {
+		t.Fatalf("Expected a single clock segment, got %+v", correction.Segments)
+	}
+
+	segment := correction.Segments[0]
+	if segment.Offset.Round(time.Millisecond) != time.Hour {
+		t.Errorf("Expected 1h offset, got %v", segment.Offset)
+	}
+	if drift := segment.Drift * 1e6; drift < -100.1 || drift > -99.9 {
+		t.Errorf("Expected -100ppm drift, got %.2fppm", drift)
+	}
+
+	corrected := correction.Correct(599, entries[599].Time)
+	if expected := gpsStart.Add(599 * time.Second); corrected.Sub(expected).Abs() > time.Millisecond {
+		t.Errorf("Last entry corrected to %v, expected %v", corrected, expected)
+	}
+}
+
+func TestEstimateTimeCorrectionNoGPS(t *testing.T) {
+	entries := []LogEntry{{Time: time.Now(), Sentence: "$IIVLW,09390,N,000.0,N*50"}}
+	if _, err := EstimateTimeCorrection(entries); err != ErrNoGPSTime {
+		t.Fatalf("Expected ErrNoGPSTime, got %v", err)
+	}
+}